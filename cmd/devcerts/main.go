@@ -0,0 +1,44 @@
+// Command devcerts writes a development CA and the certificates every TLS
+// setting needs, then prints the environment that enables them. The CA is
+// throwaway; never trust it outside development.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mr1hm/grpc-demo/internal/authz"
+	"github.com/mr1hm/grpc-demo/internal/certs"
+	"github.com/mr1hm/grpc-demo/internal/config"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory to write the CA, certificates and keys to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_, files, err := certs.WriteDev(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write development certificates: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, v := range []struct{ key, value string }{
+		{"tls_ca", files.CA},
+		{"user_tls_cert", files.UserCert},
+		{"user_tls_key", files.UserKey},
+		{"gateway_tls_cert", files.GatewayCert},
+		{"gateway_tls_key", files.GatewayKey},
+		{"gateway_client_tls_cert", files.GatewayClientCert},
+		{"gateway_client_tls_key", files.GatewayClientKey},
+		// Only the gateway's client certificate may call the User service
+		{"user_peer_allowlist", authz.AnyMethod + "=" + certs.SPIFFEID("gateway").String()},
+	} {
+		fmt.Printf("export %s%s='%s'\n", config.EnvPrefix, strings.ToUpper(v.key), v.value)
+	}
+}
@@ -0,0 +1,47 @@
+// Command gateway runs the public Gateway service on its own, calling a
+// User service at user_service_addr
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/mr1hm/grpc-demo/internal/app"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/gateway"
+	"github.com/mr1hm/grpc-demo/internal/metrics"
+)
+
+func main() {
+	cfg := app.LoadConfig(config.GatewayComponent)
+	stopTracing := app.SetupTracing(cfg, config.GatewayComponent)
+
+	gatewaySvc := gateway.NewService(cfg)
+	gatewayServer, err := gatewaySvc.Start(context.Background(), nil)
+	if err != nil {
+		cfg.Fatalf("Gateway service failed to start: %v", err)
+	}
+	metricsServer, err := metrics.Serve(cfg)
+	if err != nil {
+		cfg.Fatalf("Metrics endpoint failed to start: %v", err)
+	}
+	cfg.Infof("Gateway Service (public) running on %s, calling User service at %s", gatewayServer.Addr(), cfg.UserServiceAddr)
+
+	servers := []app.Serving{gatewayServer}
+	if metricsServer != nil {
+		servers = append(servers, metricsServer)
+	}
+	err = app.WaitForShutdown(cfg, servers...)
+	if err != nil {
+		cfg.Errorf("Shutting down after a server failed: %v", err)
+	} else {
+		cfg.Info("Gracefully shutting down...")
+	}
+	shutdown := app.NewShutdown(cfg)
+	shutdown.AddServer("Gateway", gatewayServer.Server, gatewaySvc.Health(), gatewaySvc)
+	if metricsServer != nil {
+		shutdown.OnExit(func() { metricsServer.Close() })
+	}
+	shutdown.OnExit(stopTracing)
+	os.Exit(shutdown.Run(err))
+}
@@ -1,46 +1,79 @@
+// Command grpc-demo runs the User and Gateway services together in one
+// process. Use cmd/user and cmd/gateway to deploy them separately.
 package main
 
 import (
+	"context"
 	"os"
-	"os/signal"
-	"syscall"
 
+	"github.com/mr1hm/grpc-demo/internal/app"
 	"github.com/mr1hm/grpc-demo/internal/config"
 	"github.com/mr1hm/grpc-demo/internal/gateway"
+	"github.com/mr1hm/grpc-demo/internal/metrics"
 	"github.com/mr1hm/grpc-demo/internal/user"
 )
 
 func main() {
-	cfg := config.New(":50051", ":50052")
+	cfg := app.LoadConfig(config.AllComponents)
+	stopTracing := app.SetupTracing(cfg, config.AllComponents)
 
 	// Start User Service
-	userServer := user.NewService(cfg).Start()
+	userSvc := user.NewService(cfg)
+	userServer, err := userSvc.Start(context.Background(), nil)
+	if err != nil {
+		cfg.Fatalf("User service failed to start: %v", err)
+	}
 
-	// Start Gateway Service (connects to User service internally)
-	gatewaySvc := gateway.NewService(cfg, "localhost"+cfg.UserServicePort)
-	gatewayServer := gatewaySvc.Start()
+	// Start Gateway Service (connects to User service internally, at its own
+	// listener unless user_service_addr says otherwise)
+	userAddr := cfg.UserServiceAddr
+	if userAddr == "" {
+		userAddr = userServer.DialAddr()
+	}
+	gatewaySvc := gateway.NewServiceWithAddr(cfg, userAddr)
+	gatewayServer, err := gatewaySvc.Start(context.Background(), nil)
+	if err != nil {
+		cfg.Fatalf("Gateway service failed to start: %v", err)
+	}
+
+	metricsServer, err := metrics.Serve(cfg, userSvc.Collector())
+	if err != nil {
+		cfg.Fatalf("Metrics endpoint failed to start: %v", err)
+	}
 
 	cfg.Info("===========================================")
 	cfg.Info("gRPC Demo - Inter-service Communication")
 	cfg.Info("===========================================")
-	cfg.Infof("User Service (internal) running on %s", cfg.UserServicePort)
-	cfg.Infof("Gateway Service (public) running on %s", cfg.GatewayServicePort)
+	cfg.Infof("User Service (internal) running on %s", userServer.Addr())
+	cfg.Infof("Gateway Service (public) running on %s", gatewayServer.Addr())
 	cfg.Info("-------------------------------------------")
 	cfg.Info("Test with grpcurl:")
 	cfg.Info("  # Register a user via Gateway")
 	cfg.Info("  grpcurl -plaintext -d '{\"name\": \"John\", \"email\": \"john@example.com\"}' localhost:50052 gatewaypb.GatewayService/RegisterUser")
 	cfg.Info("")
-	cfg.Info("  # Get user profile via Gateway (which calls User service internally)")
-	cfg.Info("  grpcurl -plaintext -d '{\"user_id\": \"user-1\"}' localhost:50052 gatewaypb.GatewayService/GetUserProfile")
+	cfg.Info("  # Get user profile via Gateway (which calls User service internally),")
+	cfg.Info("  # with an access token from Login unless auth_disabled is set")
+	cfg.Info("  grpcurl -plaintext -H 'authorization: Bearer <access_token>' -d '{\"user_id\": \"user-1\"}' localhost:50052 gatewaypb.GatewayService/GetUserProfile")
 	cfg.Info("===========================================")
 
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	cfg.Info("Gracefully shutting down...")
-	userServer.GracefulStop()
-	gatewayServer.GracefulStop()
-	gatewaySvc.Close()
+	// Reload runtime settings on SIGHUP or config file changes until interrupted
+	servers := []app.Serving{userServer, gatewayServer}
+	if metricsServer != nil {
+		servers = append(servers, metricsServer)
+	}
+	err = app.WaitForShutdown(cfg, servers...)
+	if err != nil {
+		cfg.Errorf("Shutting down after a server failed: %v", err)
+	} else {
+		cfg.Info("Gracefully shutting down...")
+	}
+	shutdown := app.NewShutdown(cfg)
+	// The gateway calls the User service, so it is added last to stop first
+	shutdown.AddServer("User", userServer.Server, userSvc.Health(), userSvc)
+	shutdown.AddServer("Gateway", gatewayServer.Server, gatewaySvc.Health(), gatewaySvc)
+	if metricsServer != nil {
+		shutdown.OnExit(func() { metricsServer.Close() })
+	}
+	shutdown.OnExit(stopTracing)
+	os.Exit(shutdown.Run(err))
 }
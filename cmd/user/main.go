@@ -0,0 +1,46 @@
+// Command user runs the internal User service on its own
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/mr1hm/grpc-demo/internal/app"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/metrics"
+	"github.com/mr1hm/grpc-demo/internal/user"
+)
+
+func main() {
+	cfg := app.LoadConfig(config.UserComponent)
+	stopTracing := app.SetupTracing(cfg, config.UserComponent)
+
+	userSvc := user.NewService(cfg)
+	userServer, err := userSvc.Start(context.Background(), nil)
+	if err != nil {
+		cfg.Fatalf("User service failed to start: %v", err)
+	}
+	metricsServer, err := metrics.Serve(cfg, userSvc.Collector())
+	if err != nil {
+		cfg.Fatalf("Metrics endpoint failed to start: %v", err)
+	}
+	cfg.Infof("User Service (internal) running on %s", userServer.Addr())
+
+	servers := []app.Serving{userServer}
+	if metricsServer != nil {
+		servers = append(servers, metricsServer)
+	}
+	err = app.WaitForShutdown(cfg, servers...)
+	if err != nil {
+		cfg.Errorf("Shutting down after a server failed: %v", err)
+	} else {
+		cfg.Info("Gracefully shutting down...")
+	}
+	shutdown := app.NewShutdown(cfg)
+	shutdown.AddServer("User", userServer.Server, userSvc.Health(), userSvc)
+	if metricsServer != nil {
+		shutdown.OnExit(func() { metricsServer.Close() })
+	}
+	shutdown.OnExit(stopTracing)
+	os.Exit(shutdown.Run(err))
+}
@@ -0,0 +1,107 @@
+// Package app holds the process plumbing shared by the binaries in cmd
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/tracing"
+)
+
+// LoadConfig loads the configuration for component from the command line,
+// environment and config file. It exits after printing the usage for -h, or
+// every invalid key when the configuration is bad.
+func LoadConfig(component config.Component) *config.Config {
+	cfg, err := config.Load(component, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitOK)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitUsage)
+	}
+	return cfg
+}
+
+// tracingFlushTimeout bounds how long exiting waits to export pending spans
+const tracingFlushTimeout = 5 * time.Second
+
+// SetupTracing installs the tracer provider selected by cfg for the services
+// in component. Call the returned function before exiting to flush spans.
+func SetupTracing(cfg *config.Config, component config.Component) (stop func()) {
+	shutdown, err := tracing.Setup(context.Background(), cfg, component.String())
+	if err != nil {
+		cfg.Fatalf("Failed to set up tracing: %v", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			cfg.Warnf("Failed to flush traces: %v", err)
+		}
+	}
+}
+
+// Serving is a server running in the background, such as a *server.Server or
+// a *metrics.Endpoint
+type Serving interface {
+	Addr() net.Addr
+	Done() <-chan struct{}
+	Wait() error
+}
+
+// WaitForShutdown reloads cfg on SIGHUP or when its config file changes, and
+// returns once SIGINT or SIGTERM arrives or one of servers stops serving. It
+// returns the error that stopped the server, if any.
+func WaitForShutdown(cfg *config.Config, servers ...Serving) error {
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go func() {
+		if err := cfg.Watch(watchCtx); err != nil {
+			cfg.Warnf("Config file watcher stopped: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	failed := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			select {
+			case <-srv.Done():
+				err := srv.Wait()
+				if err == nil {
+					err = errors.New("stopped unexpectedly")
+				}
+				failed <- fmt.Errorf("server on %s: %w", srv.Addr(), err)
+			case <-watchCtx.Done():
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-hup:
+			cfg.Info("SIGHUP received, reloading configuration")
+			cfg.Reload()
+		case <-quit:
+			return nil
+		case err := <-failed:
+			return err
+		}
+	}
+}
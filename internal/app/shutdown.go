@@ -0,0 +1,129 @@
+package app
+
+import (
+	"io"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// Exit codes of the binaries in cmd
+const (
+	ExitOK = 0
+	// ExitError reports a failure to shut down cleanly, such as a store that
+	// could not be flushed
+	ExitError = 1
+	// ExitUsage reports a bad command line or configuration
+	ExitUsage = 2
+	// ExitForced reports that a server still had calls in flight after
+	// shutdown_timeout and was stopped forcibly
+	ExitForced = 3
+)
+
+// Shutdown stops a process's servers in order. Servers are stopped in the
+// reverse of the order they were added, so a server added after the ones it
+// calls stops taking traffic before they do.
+type Shutdown struct {
+	cfg     *config.Config
+	servers []stoppable
+	onExit  []func()
+	sleep   func(time.Duration)
+}
+
+type stoppable struct {
+	name   string
+	server *grpc.Server
+	health *health.Server
+	closer io.Closer
+}
+
+// NewShutdown returns a Shutdown reading shutdown_drain and shutdown_timeout
+// from cfg when it runs
+func NewShutdown(cfg *config.Config) *Shutdown {
+	return &Shutdown{cfg: cfg, sleep: time.Sleep}
+}
+
+// AddServer registers server, the health service it reports through, and the
+// service behind it. closer runs once the server has stopped, to flush the
+// service's storage and connections.
+func (s *Shutdown) AddServer(name string, server *grpc.Server, health *health.Server, closer io.Closer) {
+	s.servers = append(s.servers, stoppable{name: name, server: server, health: health, closer: closer})
+}
+
+// OnExit registers fn to run after every server has stopped, in the order
+// registered
+func (s *Shutdown) OnExit(fn func()) {
+	s.onExit = append(s.onExit, fn)
+}
+
+// Run shuts the servers down and returns the exit code the process should
+// end with. cause is why the process is exiting: nil when it was asked to,
+// or the error that ended a server, which makes the exit code ExitError at
+// least. The steps are:
+//
+//  1. every server reports NOT_SERVING, for both its services and overall
+//  2. they keep serving for shutdown_drain while traffic moves away
+//  3. each stops in turn, finishing in-flight calls; once shutdown_timeout
+//     has passed in total, the rest are stopped forcibly
+//  4. each service is closed after its server stops
+//  5. the OnExit functions run
+func (s *Shutdown) Run(cause error) int {
+	current := s.cfg.Current()
+	code := ExitOK
+	if cause != nil {
+		code = ExitError
+	}
+
+	for _, srv := range s.servers {
+		srv.health.Shutdown()
+	}
+	if current.ShutdownDrain > 0 {
+		s.cfg.Infof("Draining for %s", current.ShutdownDrain)
+		s.sleep(current.ShutdownDrain)
+	}
+
+	deadline := time.Now().Add(current.ShutdownTimeout)
+	for i := len(s.servers) - 1; i >= 0; i-- {
+		srv := s.servers[i]
+		s.cfg.Infof("Stopping %s service", srv.name)
+		if !stopBy(srv.server, deadline) {
+			s.cfg.Warnf("%s service still had calls in flight after %s; stopped forcibly", srv.name, current.ShutdownTimeout)
+			code = ExitForced
+		}
+		if err := srv.closer.Close(); err != nil {
+			s.cfg.Errorf("Failed to close %s service: %v", srv.name, err)
+			if code == ExitOK {
+				code = ExitError
+			}
+		}
+	}
+
+	for _, fn := range s.onExit {
+		fn()
+	}
+	return code
+}
+
+// stopBy stops server gracefully, or forcibly at deadline. It reports whether
+// the graceful stop finished in time.
+func stopBy(server *grpc.Server, deadline time.Time) bool {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		return true
+	case <-timer.C:
+		// Stop also ends the pending GracefulStop
+		server.Stop()
+		<-stopped
+		return false
+	}
+}
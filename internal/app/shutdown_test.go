@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// closeFunc adapts a function to io.Closer
+type closeFunc func() error
+
+func (f closeFunc) Close() error { return f() }
+
+// testServer serves a health service over bufconn until the test ends
+func testServer(t *testing.T) (*grpc.Server, *health.Server, healthpb.HealthClient) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	healthSrv := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthSrv)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, healthSrv, healthpb.NewHealthClient(conn)
+}
+
+func testConfig(drain, timeout time.Duration) *config.Config {
+	cfg := config.New(":0", ":0")
+	cfg.SetOutput(io.Discard)
+	cfg.ShutdownDrain = drain
+	cfg.ShutdownTimeout = timeout
+	return cfg
+}
+
+func TestShutdown_Order(t *testing.T) {
+	userServer, userHealth, userClient := testServer(t)
+	gatewayServer, gatewayHealth, gatewayClient := testServer(t)
+
+	var events []string
+	record := func(event string) closeFunc {
+		return func() error {
+			events = append(events, event)
+			return nil
+		}
+	}
+	shutdown := NewShutdown(testConfig(time.Second, time.Second))
+	shutdown.sleep = func(d time.Duration) {
+		// Still serving while draining, but reporting NOT_SERVING
+		for name, client := range map[string]healthpb.HealthClient{"user": userClient, "gateway": gatewayClient} {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+				t.Errorf("%s health while draining = %v, %v; want NOT_SERVING", name, resp, err)
+			}
+		}
+		events = append(events, "drain "+d.String())
+	}
+	shutdown.AddServer("User", userServer, userHealth, record("close user"))
+	shutdown.AddServer("Gateway", gatewayServer, gatewayHealth, record("close gateway"))
+	shutdown.OnExit(func() { record("exit")() })
+
+	if code := shutdown.Run(nil); code != ExitOK {
+		t.Errorf("Run(nil) = %d, want %d", code, ExitOK)
+	}
+	want := []string{"drain 1s", "close gateway", "close user", "exit"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestShutdown_ExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		cause    error
+		closeErr error
+		stuck    bool
+		want     int
+	}{
+		{name: "clean", want: ExitOK},
+		{name: "server failed", cause: errors.New("accept failed"), want: ExitError},
+		{name: "close fails", closeErr: errors.New("flush failed"), want: ExitError},
+		{name: "stream outlives timeout", stuck: true, want: ExitForced},
+		{name: "forced and close fails", closeErr: errors.New("flush failed"), stuck: true, want: ExitForced},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, healthSrv, client := testServer(t)
+			if tt.stuck {
+				// A Watch stream stays open until the client or server ends it
+				stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := stream.Recv(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			closed := false
+			shutdown := NewShutdown(testConfig(0, 50*time.Millisecond))
+			shutdown.AddServer("Test", server, healthSrv, closeFunc(func() error {
+				closed = true
+				return tt.closeErr
+			}))
+
+			start := time.Now()
+			if code := shutdown.Run(tt.cause); code != tt.want {
+				t.Errorf("Run(%v) = %d, want %d", tt.cause, code, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Run() took %s", elapsed)
+			}
+			if !closed {
+				t.Error("service was not closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package auth authenticates callers of the public API by the bearer JWT in
+// their request metadata, and carries the authenticated principal in the
+// request context
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationHeader is the metadata key carrying "Bearer <token>"
+const AuthorizationHeader = "authorization"
+
+// leeway absorbs clock skew between token issuers and the gateway
+const leeway = 30 * time.Second
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Subject is the token's sub claim: the user ID of the caller
+	Subject string
+	// Scopes are the space-separated values of the token's scope claim
+	Scopes []string
+	// SessionID is the token's sid claim: the login session it was issued
+	// for, if any
+	SessionID string
+}
+
+// HasScope reports whether p was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// NewContext returns ctx carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request in ctx, if authenticated
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// claims are the JWT claims the gateway reads
+type claims struct {
+	jwt.RegisteredClaims
+	Scope     string `json:"scope,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// Options configure a Verifier. At least one of HS256Secret and Keys is
+// required; each enables the algorithms it can verify.
+type Options struct {
+	// HS256Secret verifies HS256 tokens
+	HS256Secret []byte
+	// Keys verify RS256 and ES256 tokens
+	Keys *KeySet
+	// Issuer and Audience, when set, must match the token's iss and aud
+	Issuer   string
+	Audience string
+	// Revoked, when set, rejects tokens whose sid names a revoked session
+	Revoked func(sessionID string) bool
+}
+
+// Verifier validates bearer JWTs
+type Verifier struct {
+	opts   Options
+	parser *jwt.Parser
+}
+
+// NewVerifier returns a Verifier accepting the algorithms opts has keys for
+func NewVerifier(opts Options) (*Verifier, error) {
+	var methods []string
+	if len(opts.HS256Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if opts.Keys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no HS256 secret or JWKS to verify tokens with")
+	}
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	return &Verifier{opts: opts, parser: jwt.NewParser(parserOpts...)}, nil
+}
+
+// Verify checks token's signature and claims and returns its principal
+func (v *Verifier) Verify(token string) (*Principal, error) {
+	var c claims
+	if _, err := v.parser.ParseWithClaims(token, &c, v.key); err != nil {
+		return nil, err
+	}
+	if c.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	if c.SessionID != "" && v.opts.Revoked != nil && v.opts.Revoked(c.SessionID) {
+		return nil, errors.New("session has been revoked")
+	}
+	return &Principal{Subject: c.Subject, Scopes: strings.Fields(c.Scope), SessionID: c.SessionID}, nil
+}
+
+// key returns the key verifying t. The parser has already limited t's
+// algorithm to those with keys.
+func (v *Verifier) key(t *jwt.Token) (any, error) {
+	switch alg := t.Method.Alg(); alg {
+	case jwt.SigningMethodHS256.Alg():
+		return v.opts.HS256Secret, nil
+	default:
+		kid, _ := t.Header["kid"].(string)
+		return v.opts.Keys.Key(kid, alg)
+	}
+}
+
+// bearerToken returns the token of the authorization metadata in ctx
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AuthorizationHeader)
+	if len(values) == 0 {
+		return "", errors.New("missing bearer token")
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", errors.New(`authorization is not "Bearer <token>"`)
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// authenticate verifies the bearer token of the call in ctx and returns ctx
+// carrying its principal
+func (v *Verifier) authenticate(ctx context.Context) (context.Context, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	p, err := v.Verify(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, fmt.Sprintf("invalid bearer token: %v", err))
+	}
+	return NewContext(ctx, p), nil
+}
+
+// requiresToken reports whether calls to fullMethod must be authenticated:
+// every method of service except the public ones
+func requiresToken(service, fullMethod string, public []string) bool {
+	svc, _, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return ok && svc == service && !slices.Contains(public, fullMethod)
+}
+
+// UnaryServerInterceptor rejects calls to the methods of service without a
+// valid bearer token with Unauthenticated, except calls to the public full
+// method names. Handlers find the caller with FromContext.
+func (v *Verifier) UnaryServerInterceptor(service string, public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !requiresToken(service, info.FullMethod, public) {
+			return handler(ctx, req)
+		}
+		ctx, err := v.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func (v *Verifier) StreamServerInterceptor(service string, public ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !requiresToken(service, info.FullMethod, public) {
+			return handler(srv, ss)
+		}
+		ctx, err := v.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
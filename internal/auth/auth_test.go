@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func testLogger() *logrus.Entry {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.SetLevel(logrus.WarnLevel)
+	return logrus.NewEntry(log)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, c jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"scope": "profile admin",
+		"iss":   "https://issuer.example.com",
+		"aud":   "grpc-demo",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+	}
+}
+
+func with(c jwt.MapClaims, key string, value any) jwt.MapClaims {
+	c[key] = value
+	if value == nil {
+		delete(c, key)
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	keys, err := LoadKeySet(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := NewVerifier(Options{HS256Secret: secret, Keys: keys, Issuer: "https://issuer.example.com", Audience: "grpc-demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksOnly, err := NewVerifier(Options{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		token    string
+		wantErr  bool
+	}{
+		{name: "HS256", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, validClaims())},
+		{name: "RS256", verifier: both, token: sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims())},
+		{name: "ES256", verifier: both, token: sign(t, jwt.SigningMethodES256, "ec-1", ecKey, validClaims())},
+		{name: "ES256 without kid", verifier: both, token: sign(t, jwt.SigningMethodES256, "", ecKey, validClaims())},
+		{name: "expired", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix())), wantErr: true},
+		{name: "no expiry", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "exp", nil)), wantErr: true},
+		{name: "issued in the future", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "iat", time.Now().Add(time.Hour).Unix())), wantErr: true},
+		{name: "no subject", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "sub", nil)), wantErr: true},
+		{name: "wrong secret", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", []byte("another secret of at least 32 bytes"), validClaims()), wantErr: true},
+		{name: "wrong issuer", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "iss", "https://evil.example.com")), wantErr: true},
+		{name: "wrong audience", verifier: both, token: sign(t, jwt.SigningMethodHS256, "", secret, with(validClaims(), "aud", "other")), wantErr: true},
+		{name: "unknown kid", verifier: both, token: sign(t, jwt.SigningMethodES256, "ec-2", ecKey, validClaims()), wantErr: true},
+		{name: "signed by another key", verifier: both, token: sign(t, jwt.SigningMethodES256, "ec-1", otherEC, validClaims()), wantErr: true},
+		{name: "alg none", verifier: both, token: sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, validClaims()), wantErr: true},
+		{name: "HS256 without a secret", verifier: jwksOnly, token: sign(t, jwt.SigningMethodHS256, "", secret, validClaims()), wantErr: true},
+		{name: "not a JWT", verifier: both, token: "not.a.jwt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.verifier.Verify(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got principal %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Subject != "user-1" || !p.HasScope("admin") || p.HasScope("adm") {
+				t.Errorf("got principal %+v", p)
+			}
+		})
+	}
+}
+
+func TestNewVerifier_NoKeys(t *testing.T) {
+	if _, err := NewVerifier(Options{Issuer: "https://issuer.example.com"}); err == nil {
+		t.Error("expected error without keys")
+	}
+}
+
+func TestKeySet_Reload(t *testing.T) {
+	first, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, ecJWK("first", &first.PublicKey))
+	keys, err := LoadKeySet(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.checkEvery = 0
+	v, err := NewVerifier(Options{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFirst := sign(t, jwt.SigningMethodES256, "first", first, validClaims())
+	tokenSecond := sign(t, jwt.SigningMethodES256, "second", second, validClaims())
+
+	if _, err := v.Verify(tokenSecond); err == nil {
+		t.Fatal("expected the second key to be unknown before the reload")
+	}
+
+	// Rotate the key; a later modification time marks the file changed
+	writeJWKS(t, path, ecJWK("second", &second.PublicKey))
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(tokenSecond); err != nil {
+		t.Errorf("second key after the reload: %v", err)
+	}
+	if _, err := v.Verify(tokenFirst); err == nil {
+		t.Error("expected the first key to be gone after the reload")
+	}
+
+	// A broken file keeps the previous keys
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(tokenSecond); err != nil {
+		t.Errorf("second key after a failed reload: %v", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := ecJWK("ec", &ecKey.PublicKey)
+	offCurve := ecJWK("off", &ecKey.PublicKey)
+	offCurve["y"] = b64(big.NewInt(1).FillBytes(make([]byte, 32)))
+	encryption := ecJWK("enc", &ecKey.PublicKey)
+	encryption["use"] = "enc"
+
+	tests := []struct {
+		name     string
+		keys     []map[string]string
+		wantKeys int
+		wantErr  bool
+	}{
+		{name: "EC key", keys: []map[string]string{ec}, wantKeys: 1},
+		{name: "unsupported and encryption keys are skipped", keys: []map[string]string{ec, {"kty": "oct", "k": "c2VjcmV0"}, encryption}, wantKeys: 1},
+		{name: "only unsupported keys", keys: []map[string]string{{"kty": "OKP", "crv": "Ed25519", "x": "AA"}}, wantErr: true},
+		{name: "short RSA key", keys: []map[string]string{rsaJWK("small", &small.PublicKey)}, wantErr: true},
+		{name: "point off the curve", keys: []map[string]string{offCurve}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(map[string]any{"keys": tt.keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, err := parseJWKS(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWKS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("got %d keys, want %d", len(keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	v, err := NewVerifier(Options{HS256Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const service = "demo.Service"
+	interceptor := v.UnaryServerInterceptor(service, "/demo.Service/Public")
+	token := sign(t, jwt.SigningMethodHS256, "", secret, validClaims())
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantCode      codes.Code
+		wantPrincipal bool
+	}{
+		{name: "valid token", method: "/demo.Service/Private", authorization: "Bearer " + token, wantPrincipal: true},
+		{name: "lowercase scheme", method: "/demo.Service/Private", authorization: "bearer " + token, wantPrincipal: true},
+		{name: "missing token", method: "/demo.Service/Private", wantCode: codes.Unauthenticated},
+		{name: "basic auth", method: "/demo.Service/Private", authorization: "Basic dXNlcjpwYXNz", wantCode: codes.Unauthenticated},
+		{name: "invalid token", method: "/demo.Service/Private", authorization: "Bearer " + token + "x", wantCode: codes.Unauthenticated},
+		{name: "public method", method: "/demo.Service/Public"},
+		{name: "other service", method: "/grpc.health.v1.Health/Check"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(AuthorizationHeader, tt.authorization))
+			}
+			var gotPrincipal bool
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req any) (any, error) {
+				_, gotPrincipal = FromContext(ctx)
+				return nil, nil
+			})
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("got code %v, want %v (%v)", got, tt.wantCode, err)
+			}
+			if gotPrincipal != tt.wantPrincipal {
+				t.Errorf("handler saw principal = %v, want %v", gotPrincipal, tt.wantPrincipal)
+			}
+		})
+	}
+}
+
+func TestSigner(t *testing.T) {
+	revoked := map[string]bool{"session-2": true}
+	v, err := NewVerifier(Options{
+		HS256Secret: secret,
+		Issuer:      "https://issuer.example.com",
+		Audience:    "grpc-demo",
+		Revoked:     func(id string) bool { return revoked[id] },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewSigner(secret, "https://issuer.example.com", "grpc-demo")
+
+	want := &Principal{Subject: "user-1", Scopes: []string{"admin"}, SessionID: "session-1"}
+	token, expiresAt, err := signer.Sign(want, time.Minute)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > time.Minute {
+		t.Errorf("token expires in %s, want within a minute", d)
+	}
+	got, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if got.Subject != want.Subject || got.SessionID != want.SessionID || !got.HasScope("admin") {
+		t.Errorf("Verify = %+v, want %+v", got, want)
+	}
+
+	var c claims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &c); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := signer.Sign(want, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c2 claims
+	if _, _, err := jwt.NewParser().ParseUnverified(again, &c2); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" || c.ID == c2.ID {
+		t.Errorf("jti %q and %q should be unique", c.ID, c2.ID)
+	}
+
+	revokedToken, _, err := signer.Sign(&Principal{Subject: "user-1", SessionID: "session-2"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(revokedToken); err == nil {
+		t.Error("expected a token of a revoked session to be rejected")
+	}
+}
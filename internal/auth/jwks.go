@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jwksCheckInterval is how often a KeySet looks for a changed file at most
+const jwksCheckInterval = time.Second
+
+// jwk is the subset of RFC 7517 needed for RS256 and ES256 public keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a verification key and the algorithm it is for
+type publicKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet holds the RS256 and ES256 public keys of a local JWKS file,
+// reloading them when the file changes. A file that fails to load keeps the
+// previous keys.
+type KeySet struct {
+	path string
+	log  *logrus.Entry
+
+	mu         sync.Mutex
+	keys       []publicKey
+	modTime    time.Time
+	checkedAt  time.Time
+	checkEvery time.Duration
+}
+
+// LoadKeySet reads the JWKS file at path
+func LoadKeySet(path string, log *logrus.Entry) (*KeySet, error) {
+	s := &KeySet{path: path, log: log, checkEvery: jwksCheckInterval}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	s.checkedAt = time.Now()
+	return s, nil
+}
+
+func (s *KeySet) load(modTime time.Time) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.keys, s.modTime = keys, modTime
+	return nil
+}
+
+// Key returns the key for alg named kid, or the only key for alg when kid is
+// empty
+func (s *KeySet) Key(kid, alg string) (crypto.PublicKey, error) {
+	var match []publicKey
+	for _, k := range s.current() {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			match = append(match, k)
+		}
+	}
+	switch {
+	case len(match) == 1:
+		return match[0].key, nil
+	case len(match) > 1:
+		return nil, fmt.Errorf("token has no kid and %d %s keys match", len(match), alg)
+	case kid == "":
+		return nil, fmt.Errorf("no %s key", alg)
+	default:
+		return nil, fmt.Errorf("no %s key with kid %q", alg, kid)
+	}
+}
+
+// current returns the keys, reloading them first if the file changed since
+// it was last checked
+func (s *KeySet) current() []publicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checkedAt) < s.checkEvery {
+		return s.keys
+	}
+	s.checkedAt = time.Now()
+	info, err := os.Stat(s.path)
+	if err == nil && info.ModTime().Equal(s.modTime) {
+		return s.keys
+	}
+	if err == nil {
+		err = s.load(info.ModTime())
+	}
+	if err != nil {
+		s.log.WithError(err).Warn("Failed to reload JWKS; keeping the previous keys")
+	} else {
+		s.log.WithField("keys", len(s.keys)).Info("Reloaded JWKS")
+	}
+	return s.keys
+}
+
+// parseJWKS parses a JSON Web Key Set, keeping its RS256 and ES256 signing
+// keys. Keys for other algorithms or for encryption are skipped.
+func parseJWKS(data []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+	var keys []publicKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, alg, err := k.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid %q): %w", i, k.Kid, err)
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RS256 or ES256 signing keys")
+	}
+	return keys, nil
+}
+
+var errUnsupportedKey = errors.New("unsupported key type")
+
+func (k jwk) publicKey() (crypto.PublicKey, string, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, "", fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, "", errors.New("bad exponent e")
+		}
+		if n.BitLen() < 2048 {
+			return nil, "", fmt.Errorf("%d-bit RSA key is too short", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, "RS256", nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, "", fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, "", fmt.Errorf("y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := key.ECDH(); err != nil {
+			return nil, "", errors.New("point is not on P-256")
+		}
+		return key, "ES256", nil
+	default:
+		return nil, "", errUnsupportedKey
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("not unpadded base64url")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
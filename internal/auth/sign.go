@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer issues HS256 access tokens, which a Verifier with the same secret,
+// issuer and audience accepts
+type Signer struct {
+	secret   []byte
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewSigner returns a Signer stamping tokens with issuer and audience when
+// they are set
+func NewSigner(secret []byte, issuer, audience string) *Signer {
+	return &Signer{secret: secret, issuer: issuer, audience: audience, now: time.Now}
+}
+
+// Sign returns an access token for p lasting ttl, and when it expires. Each
+// token gets a unique jti.
+func (s *Signer) Sign(p *Principal, ttl time.Duration) (string, time.Time, error) {
+	now := s.now().Truncate(time.Second)
+	expiresAt := now.Add(ttl)
+	jti := make([]byte, 16)
+	rand.Read(jti)
+	c := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        base64.RawURLEncoding.EncodeToString(jti),
+			Subject:   p.Subject,
+			Issuer:    s.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Scope:     strings.Join(p.Scopes, " "),
+		SessionID: p.SessionID,
+	}
+	if s.audience != "" {
+		c.Audience = jwt.ClaimStrings{s.audience}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
@@ -0,0 +1,130 @@
+// Package authz authorizes calls by the identity in the caller's verified
+// TLS client certificate: its SPIFFE ID when it has one, otherwise its common
+// name
+package authz
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// AnyMethod is the Allowlist rule applying to methods without their own
+const AnyMethod = "*"
+
+// Allowlist maps method names, such as "CreateUser", to the peer identities
+// that may call them
+type Allowlist map[string][]string
+
+// ParseAllowlist parses rules written as
+// "*=spiffe://grpc-demo.local/gateway,ListUsers=spiffe://grpc-demo.local/gateway|reporting",
+// each naming a method, or AnyMethod, and the identities allowed to call it
+// separated by "|"
+func ParseAllowlist(s string) (Allowlist, error) {
+	list := make(Allowlist)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		method, ids, ok := strings.Cut(item, "=")
+		method = strings.TrimSpace(method)
+		if !ok || method == "" {
+			return nil, fmt.Errorf("%q is not method=identity", item)
+		}
+		if _, dup := list[method]; dup {
+			return nil, fmt.Errorf("%q: method %s listed twice", item, method)
+		}
+		for _, id := range strings.Split(ids, "|") {
+			if id = strings.TrimSpace(id); id == "" {
+				return nil, fmt.Errorf("%q: empty identity", item)
+			}
+			list[method] = append(list[method], id)
+		}
+	}
+	return list, nil
+}
+
+// Allows reports whether identity may call method. Methods without a rule
+// follow the AnyMethod rule; without that, nobody may call them.
+func (a Allowlist) Allows(method, identity string) bool {
+	ids, ok := a[method]
+	if !ok {
+		ids = a[AnyMethod]
+	}
+	return identity != "" && slices.Contains(ids, identity)
+}
+
+// Identity returns the identity of cert: its first SPIFFE URI SAN, or else
+// its subject common name
+func Identity(cert *x509.Certificate) string {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String()
+		}
+	}
+	return cert.Subject.CommonName
+}
+
+// PeerIdentity returns the identity of the verified client certificate of
+// the call in ctx, or "" when the caller presented none
+func PeerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return Identity(info.State.VerifiedChains[0][0])
+}
+
+// UnaryServerInterceptor rejects calls to the methods of service whose
+// caller the current allowlist does not allow, with PermissionDenied. Calls
+// to other services, such as health checks, are let through. A nil
+// allowlist disables the check.
+func UnaryServerInterceptor(service string, allowlist func() Allowlist) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorize(ctx, service, info.FullMethod, allowlist()); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(service string, allowlist func() Allowlist) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), service, info.FullMethod, allowlist()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func authorize(ctx context.Context, service, fullMethod string, allowlist Allowlist) error {
+	if allowlist == nil {
+		return nil
+	}
+	svc, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok || svc != service {
+		return nil
+	}
+	identity := PeerIdentity(ctx)
+	if !allowlist.Allows(method, identity) {
+		if identity == "" {
+			return status.Errorf(codes.PermissionDenied, "%s requires a client certificate", method)
+		}
+		return status.Errorf(codes.PermissionDenied, "%s may not call %s", identity, method)
+	}
+	return nil
+}
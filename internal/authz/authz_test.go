@@ -0,0 +1,88 @@
+package authz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Allowlist
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: Allowlist{}},
+		{name: "rules", in: "*=spiffe://grpc-demo.local/gateway, ListUsers = spiffe://grpc-demo.local/gateway | reporting", want: Allowlist{
+			"*":         {"spiffe://grpc-demo.local/gateway"},
+			"ListUsers": {"spiffe://grpc-demo.local/gateway", "reporting"},
+		}},
+		{name: "missing identities", in: "CreateUser", wantErr: true},
+		{name: "empty identity", in: "CreateUser=gateway|", wantErr: true},
+		{name: "missing method", in: "=gateway", wantErr: true},
+		{name: "method twice", in: "GetUser=gateway,GetUser=reporting", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAllowlist(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAllowlist(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAllowlist(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	list := Allowlist{
+		AnyMethod:    {"gateway"},
+		"ListUsers":  {"gateway", "reporting"},
+		"DeleteUser": {"admin"},
+	}
+	tests := []struct {
+		method, identity string
+		want             bool
+	}{
+		{"GetUser", "gateway", true},
+		{"GetUser", "reporting", false},
+		{"ListUsers", "reporting", true},
+		{"DeleteUser", "admin", true},
+		// A method's own rule replaces the AnyMethod rule
+		{"DeleteUser", "gateway", false},
+		{"GetUser", "", false},
+	}
+	for _, tt := range tests {
+		if got := list.Allows(tt.method, tt.identity); got != tt.want {
+			t.Errorf("Allows(%q, %q) = %v, want %v", tt.method, tt.identity, got, tt.want)
+		}
+	}
+
+	if (Allowlist{"GetUser": {"gateway"}}).Allows("ListUsers", "gateway") {
+		t.Error("method without a rule allowed with no AnyMethod rule")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://grpc-demo.local/gateway")
+	web, _ := url.Parse("https://gateway.example.com")
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{name: "SPIFFE ID", cert: &x509.Certificate{URIs: []*url.URL{web, spiffe}, Subject: pkix.Name{CommonName: "gw"}}, want: "spiffe://grpc-demo.local/gateway"},
+		{name: "common name", cert: &x509.Certificate{URIs: []*url.URL{web}, Subject: pkix.Name{CommonName: "gw"}}, want: "gw"},
+		{name: "neither", cert: &x509.Certificate{}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := Identity(tt.cert); got != tt.want {
+			t.Errorf("%s: Identity() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
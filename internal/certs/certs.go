@@ -0,0 +1,210 @@
+// Package certs builds TLS configurations from PEM files on disk. Files are
+// re-read when they change, so rotated certificates take effect on the next
+// handshake without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkInterval is how often a Source looks for changed files at most
+const checkInterval = time.Second
+
+// Source is a certificate and key, a CA bundle, or both, read from PEM files
+// and reloaded when they change. A failed reload, such as one racing the
+// writer of a rotated pair, keeps the previous contents and is retried.
+type Source struct {
+	certFile, keyFile, caFile string
+	log                       *logrus.Entry
+
+	mu         sync.Mutex
+	cert       *tls.Certificate
+	pool       *x509.CertPool
+	stamps     []fileStamp
+	checkedAt  time.Time
+	checkEvery time.Duration
+}
+
+// fileStamp identifies one version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewSource loads certFile and keyFile, caFile, or all three; empty names
+// are skipped. Failures to reload later are logged to log.
+func NewSource(certFile, keyFile, caFile string, log *logrus.Entry) (*Source, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("certificate and key must be given together")
+	}
+	s := &Source{certFile: certFile, keyFile: keyFile, caFile: caFile, log: log, checkEvery: checkInterval}
+	stamps, err := s.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(stamps); err != nil {
+		return nil, err
+	}
+	s.checkedAt = time.Now()
+	return s, nil
+}
+
+func (s *Source) files() []string {
+	var files []string
+	for _, f := range []string{s.certFile, s.keyFile, s.caFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (s *Source) stat() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, f := range s.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+// load reads the files, recording stamps as the version loaded
+func (s *Source) load(stamps []fileStamp) error {
+	var cert *tls.Certificate
+	if s.certFile != "" {
+		c, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return fmt.Errorf("load key pair %s: %w", s.certFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if s.caFile != "" {
+		pem, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in CA bundle %s", s.caFile)
+		}
+	}
+	s.cert, s.pool, s.stamps = cert, pool, stamps
+	return nil
+}
+
+// current returns the certificate and CA pool, reloading them first if the
+// files changed since they were last checked
+func (s *Source) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checkedAt) < s.checkEvery {
+		return s.cert, s.pool
+	}
+	s.checkedAt = time.Now()
+
+	stamps, err := s.stat()
+	if err == nil && !changed(s.stamps, stamps) {
+		return s.cert, s.pool
+	}
+	if err == nil {
+		err = s.load(stamps)
+	}
+	if err != nil {
+		s.log.WithError(err).Warn("Failed to reload TLS files; keeping the previous ones")
+	} else {
+		s.log.WithField("files", s.files()).Info("Reloaded TLS files")
+	}
+	return s.cert, s.pool
+}
+
+func changed(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// Certificate returns the current certificate
+func (s *Source) Certificate() (*tls.Certificate, error) {
+	cert, _ := s.current()
+	if cert == nil {
+		return nil, errors.New("no certificate configured")
+	}
+	return cert, nil
+}
+
+// ServerConfig returns a server TLS configuration presenting src's
+// certificate. When src has a CA bundle, clients must present a certificate
+// it verifies.
+func ServerConfig(src *Source) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := src.current()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.ClientCAs = pool
+			}
+			return c, nil
+		},
+	}
+}
+
+// ClientConfig returns a client TLS configuration verifying servers against
+// src's CA bundle and, when src has a certificate, presenting it
+func ClientConfig(src *Source) *tls.Config {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Verified by VerifyConnection instead, against the current bundle
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := src.current()
+			return verifyServer(cs, pool)
+		},
+	}
+	if src.certFile != "" {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return src.Certificate()
+		}
+	}
+	return c
+}
+
+// verifyServer does what crypto/tls does for a client without
+// InsecureSkipVerify, with roots as the trusted CAs
+func verifyServer(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       cs.ServerName,
+	})
+	return err
+}
@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"crypto/tls"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Entry {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return logrus.NewEntry(log)
+}
+
+// handshake connects a client and a server over loopback TCP and returns
+// the client's and the server's handshake errors
+func handshake(t *testing.T, client, server *tls.Config) (clientErr, serverErr error) {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		err = conn.(*tls.Conn).Handshake()
+		if err == nil {
+			// In TLS 1.3 the server learns of a rejected certificate on
+			// its first read
+			_, err = conn.Read(make([]byte, 1))
+		}
+		done <- err
+	}()
+
+	conn, clientErr := tls.Dial("tcp", lis.Addr().String(), client)
+	if clientErr == nil {
+		// Let the server read, and the client see any alert it sends
+		conn.Write([]byte{1})
+		_, clientErr = conn.Read(make([]byte, 1))
+		if clientErr == io.EOF {
+			clientErr = nil
+		}
+		conn.Close()
+	}
+	return clientErr, <-done
+}
+
+func TestHandshake(t *testing.T) {
+	dir := t.TempDir()
+	_, files, err := WriteDev(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherClient := filepath.Join(dir, "other.pem")
+	otherClientKey := filepath.Join(dir, "other-key.pem")
+	if err := WriteIssued(other, DevCert{CommonName: "gateway", URIs: []*url.URL{SPIFFEID("gateway")}, Client: true}, otherClient, otherClientKey); err != nil {
+		t.Fatal(err)
+	}
+
+	source := func(cert, key, caFile string) *Source {
+		t.Helper()
+		src, err := NewSource(cert, key, caFile, testLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+	mtlsServer := ServerConfig(source(files.UserCert, files.UserKey, files.CA))
+
+	tests := []struct {
+		name       string
+		client     *tls.Config
+		server     *tls.Config
+		serverName string
+		wantErr    bool
+	}{
+		{name: "mutual TLS", client: ClientConfig(source(files.GatewayClientCert, files.GatewayClientKey, files.CA)), server: mtlsServer, serverName: "localhost"},
+		{name: "server by IP", client: ClientConfig(source(files.GatewayClientCert, files.GatewayClientKey, files.CA)), server: mtlsServer, serverName: "127.0.0.1"},
+		{name: "TLS without client certificate", client: ClientConfig(source("", "", files.CA)), server: ServerConfig(source(files.GatewayCert, files.GatewayKey, "")), serverName: "localhost"},
+		{name: "missing client certificate", client: ClientConfig(source("", "", files.CA)), server: mtlsServer, serverName: "localhost", wantErr: true},
+		{name: "client certificate from another CA", client: ClientConfig(source(otherClient, otherClientKey, files.CA)), server: mtlsServer, serverName: "localhost", wantErr: true},
+		{name: "server certificate from another CA", client: ClientConfig(source(files.GatewayClientCert, files.GatewayClientKey, otherCAFile(t, other))), server: mtlsServer, serverName: "localhost", wantErr: true},
+		{name: "wrong server name", client: ClientConfig(source(files.GatewayClientCert, files.GatewayClientKey, files.CA)), server: mtlsServer, serverName: "user.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.client.ServerName = tt.serverName
+			clientErr, serverErr := handshake(t, tt.client, tt.server)
+			if gotErr := clientErr != nil || serverErr != nil; gotErr != tt.wantErr {
+				t.Errorf("handshake errors = %v, %v; wantErr %v", clientErr, serverErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func otherCAFile(t *testing.T, ca *DevCA) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "other-ca.pem")
+	if err := os.WriteFile(path, ca.CertPEM(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSource_Reload(t *testing.T) {
+	ca, files, err := WriteDev(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewSource(files.UserCert, files.UserKey, "", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.checkEvery = 0
+	serial := func() string {
+		t.Helper()
+		cert, err := src.Certificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.SerialNumber.String()
+	}
+	first := serial()
+
+	// A key that does not match the certificate yet keeps the old pair
+	if err := os.WriteFile(files.UserKey, []byte("partial write"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(); got != first {
+		t.Errorf("serial after a bad key = %s, want the previous %s", got, first)
+	}
+
+	if err := WriteIssued(ca, DevCert{CommonName: "user", DNSNames: []string{"localhost"}, Server: true}, files.UserCert, files.UserKey); err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(); got == first {
+		t.Errorf("serial after rotation = %s, want a new certificate", got)
+	}
+}
@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+)
+
+// devValidity is how long development certificates last
+const devValidity = 90 * 24 * time.Hour
+
+// DevCA is a throwaway certificate authority for development and tests.
+// Never trust it outside them.
+type DevCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// NewDevCA creates a CA with a fresh key
+func NewDevCA() (*DevCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "grpc-demo development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &DevCA{cert: cert, key: key, pem: pemBlock("CERTIFICATE", der)}, nil
+}
+
+// CertPEM returns the CA certificate in PEM form
+func (ca *DevCA) CertPEM() []byte {
+	return ca.pem
+}
+
+// DevCert describes a certificate for DevCA.Issue
+type DevCert struct {
+	CommonName string
+	// DNSNames and IPs name a server
+	DNSNames []string
+	IPs      []net.IP
+	// URIs identify a workload, such as spiffe://grpc-demo.local/gateway
+	URIs []*url.URL
+	// Client and Server select the extended key usages
+	Client, Server bool
+}
+
+// Issue signs a certificate for spec with a fresh key, returning both in PEM
+// form
+func (ca *DevCA) Issue(spec DevCert) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: spec.CommonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     spec.DNSNames,
+		IPAddresses:  spec.IPs,
+		URIs:         spec.URIs,
+	}
+	if spec.Server {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if spec.Client {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pemBlock("CERTIFICATE", der), pemBlock("PRIVATE KEY", keyDER), nil
+}
+
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+// TrustDomain is the SPIFFE trust domain of the development certificates
+const TrustDomain = "grpc-demo.local"
+
+// SPIFFEID returns the SPIFFE ID of workload in TrustDomain
+func SPIFFEID(workload string) *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: TrustDomain, Path: "/" + workload}
+}
+
+// DevFiles are the paths WriteDev wrote
+type DevFiles struct {
+	CA                                  string
+	UserCert, UserKey                   string
+	GatewayCert, GatewayKey             string
+	GatewayClientCert, GatewayClientKey string
+}
+
+// WriteDev creates a development CA and writes it to dir with certificates
+// for every TLS setting: the User service's and the public gateway's server
+// certificates for localhost, and the gateway's client certificate
+// identified as SPIFFEID("gateway"). The CA is returned to issue more.
+func WriteDev(dir string) (*DevCA, DevFiles, error) {
+	ca, err := NewDevCA()
+	if err != nil {
+		return nil, DevFiles{}, err
+	}
+	localhost := DevCert{DNSNames: []string{"localhost"}, IPs: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, Server: true}
+	user, gateway := localhost, localhost
+	user.CommonName, user.URIs = "user", []*url.URL{SPIFFEID("user")}
+	gateway.CommonName = "gateway"
+	client := DevCert{CommonName: "gateway", URIs: []*url.URL{SPIFFEID("gateway")}, Client: true}
+
+	f := DevFiles{CA: filepath.Join(dir, "ca.pem")}
+	if err := os.WriteFile(f.CA, ca.CertPEM(), 0o644); err != nil {
+		return nil, DevFiles{}, err
+	}
+	for _, c := range []struct {
+		name      string
+		spec      DevCert
+		cert, key *string
+	}{
+		{"user", user, &f.UserCert, &f.UserKey},
+		{"gateway", gateway, &f.GatewayCert, &f.GatewayKey},
+		{"gateway-client", client, &f.GatewayClientCert, &f.GatewayClientKey},
+	} {
+		*c.cert = filepath.Join(dir, c.name+".pem")
+		*c.key = filepath.Join(dir, c.name+"-key.pem")
+		if err := WriteIssued(ca, c.spec, *c.cert, *c.key); err != nil {
+			return nil, DevFiles{}, fmt.Errorf("%s certificate: %w", c.name, err)
+		}
+	}
+	return ca, f, nil
+}
+
+// WriteIssued issues a certificate for spec and writes it and its key
+func WriteIssued(ca *DevCA, spec DevCert, certFile, keyFile string) error {
+	certPEM, keyPEM, err := ca.Issue(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0o644)
+}
+
+// Configure points every TLS setting of cfg at f
+func (f DevFiles) Configure(cfg *config.Config) {
+	cfg.TLSCA = f.CA
+	cfg.UserTLSCert, cfg.UserTLSKey = f.UserCert, f.UserKey
+	cfg.GatewayTLSCert, cfg.GatewayTLSKey = f.GatewayCert, f.GatewayKey
+	cfg.GatewayClientTLSCert, cfg.GatewayClientTLSKey = f.GatewayClientCert, f.GatewayClientKey
+}
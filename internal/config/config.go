@@ -1,6 +1,9 @@
 package config
 
 import (
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/logging"
 	"github.com/sirupsen/logrus"
 )
 
@@ -8,12 +11,166 @@ type Config struct {
 	*logrus.Logger
 	UserServicePort    string
 	GatewayServicePort string
+	// UserServiceAddr is the address the gateway dials to reach the User service.
+	// In the all-in-one binary it is empty unless set, and the gateway dials
+	// the User service's own listener.
+	UserServiceAddr string
+	// LogLevel is the logrus level name, e.g. "info" or "debug"
+	LogLevel string
+	// LogFormat is "text" (default) or "json"
+	LogFormat string
+	// LogLevels overrides LogLevel per package, e.g. "user=debug,gateway=warn"
+	LogLevels string
+
+	// TracingExporter is where spans are sent: "none" (default) or "otlp"
+	TracingExporter string
+	// TracingEndpoint is the OTLP/gRPC collector URL; http:// connects without TLS
+	TracingEndpoint string
+	// MetricsPort is the listen address of the Prometheus /metrics endpoint; empty disables it.
+	// Each binary has its own default so they can run on one host.
+	MetricsPort string
+
+	// ShutdownDrain is how long servers keep serving after reporting NOT_SERVING
+	// on shutdown, so load balancers stop routing to them first
+	ShutdownDrain time.Duration
+	// ShutdownTimeout bounds waiting for in-flight calls once draining ends;
+	// servers still busy after it are stopped forcibly
+	ShutdownTimeout time.Duration
+
+	// TLSCA is the PEM CA bundle of the internal gateway to User service hop:
+	// the User service verifies client certificates against it and the gateway
+	// verifies the User service's. Empty leaves the hop in plaintext.
+	TLSCA string
+	// UserTLSCert and UserTLSKey are the User service's certificate and key;
+	// when set it serves mutual TLS, requiring client certificates from TLSCA
+	UserTLSCert string
+	UserTLSKey  string
+	// UserPeerAllowlist limits which client certificate identities may call
+	// each User service method, e.g. "*=spiffe://grpc-demo.local/gateway".
+	// Empty lets every verified client call every method.
+	UserPeerAllowlist string
+	// GatewayTLSCert and GatewayTLSKey are the certificate and key of the
+	// gateway's public listener; when empty it serves plaintext
+	GatewayTLSCert string
+	GatewayTLSKey  string
+	// GatewayClientTLSCert and GatewayClientTLSKey are the client certificate
+	// and key the gateway presents to the User service
+	GatewayClientTLSCert string
+	GatewayClientTLSKey  string
+
+	// JWTHS256Secret verifies HS256 bearer tokens on the gateway
+	JWTHS256Secret string
+	// JWTJWKSPath is a local JWKS file whose keys verify RS256 and ES256
+	// bearer tokens on the gateway. The gateway needs it or JWTHS256Secret
+	// unless AuthDisabled is set.
+	JWTJWKSPath string
+	// AuthDisabled runs the gateway without JWT keys, letting every caller act
+	// on any account. It is meant for local development only.
+	AuthDisabled bool
+	// JWTIssuer and JWTAudience, when set, must match each token's iss and aud
+	JWTIssuer   string
+	JWTAudience string
+	// JWTAdminScope is the token scope that may act on any user's account
+	JWTAdminScope string
+	// AccessTokenTTL is how long the access tokens Login and RefreshToken
+	// issue last. They are signed with JWTHS256Secret.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long an unused refresh token stays redeemable.
+	// Login sessions live in the gateway's memory: a restart logs every user
+	// out, and a refresh token only works on the gateway that issued it, so
+	// Login needs a single gateway instance.
+	RefreshTokenTTL time.Duration
+
+	// StoreDriver selects the user store implementation ("memory", "file" or "sql")
+	StoreDriver string
+	// StorePath is the directory holding the file store's snapshot and write-ahead log
+	StorePath string
+	// StoreSnapshotEvery compacts the write-ahead log after this many records (0 uses the default)
+	StoreSnapshotEvery int
+	// StoreTruncateCorruptLog discards a torn or corrupt log tail on startup instead of refusing to start
+	StoreTruncateCorruptLog bool
+	// StoreSQLDriver is the database/sql driver name used by the sql store
+	StoreSQLDriver string
+	// StoreDSN is the data source name used by the sql store
+	StoreDSN string
+
+	// PageTokenSecret signs ListUsers page tokens. Replicas must share it;
+	// when empty a random per-process key is used.
+	PageTokenSecret string
+
+	// GatewayErrorDetails decides which user service error details the gateway
+	// forwards to clients: "strip", "public" (default) or "all"
+	GatewayErrorDetails string
+	// UpstreamTimeout bounds each gateway call to the User service; 0 disables it
+	UpstreamTimeout time.Duration
+	// UpstreamHealthInterval is how often the gateway checks the User service's health
+	UpstreamHealthInterval time.Duration
+
+	// MailOutboxPath is the file outbound email is appended to, one JSON
+	// message per line. When empty, email is dropped once the log
+	// records that it was sent; the log never shows the recipient or body.
+	MailOutboxPath string
+	// VerificationTokenTTL is how long an email verification token stays valid
+	VerificationTokenTTL time.Duration
+	// PasswordHashConcurrency bounds the argon2id hashes computed at once, each
+	// taking 64 MiB; password calls beyond it fail with ResourceExhausted
+	// (0 allows one per CPU)
+	PasswordHashConcurrency int
+
+	components Component // Services whose keys were loaded
+	reload     *reloader
+	loggers    *logging.Loggers // Shared by every reloaded Config
 }
 
 func New(userServicePort, gatewayServicePort string) *Config {
 	return &Config{
-		Logger:             logrus.New(),
-		UserServicePort:    userServicePort,
-		GatewayServicePort: gatewayServicePort,
+		Logger:                 logrus.New(),
+		UserServicePort:        userServicePort,
+		GatewayServicePort:     gatewayServicePort,
+		UserServiceAddr:        "localhost:50051",
+		LogLevel:               "info",
+		LogFormat:              logging.FormatText,
+		TracingExporter:        "none",
+		TracingEndpoint:        "http://localhost:4317",
+		MetricsPort:            ":9090",
+		ShutdownDrain:          5 * time.Second,
+		ShutdownTimeout:        30 * time.Second,
+		StoreDriver:            "memory",
+		StoreSQLDriver:         "sqlite3",
+		GatewayErrorDetails:    "public",
+		UpstreamTimeout:        10 * time.Second,
+		UpstreamHealthInterval: 5 * time.Second,
+		JWTAdminScope:          "admin",
+		AccessTokenTTL:         15 * time.Minute,
+		RefreshTokenTTL:        7 * 24 * time.Hour,
+		components:             AllComponents,
+		VerificationTokenTTL:   24 * time.Hour,
+	}
+}
+
+// applyLogging sets the logger's level and format and the package loggers'
+// levels from LogLevel, LogFormat and LogLevels, which must be valid
+func (c *Config) applyLogging() {
+	if level, err := logrus.ParseLevel(c.LogLevel); err == nil {
+		c.SetLevel(level)
+	}
+	if formatter, err := logging.Formatter(c.LogFormat); err == nil {
+		c.SetFormatter(formatter)
+	}
+	if levels, err := logging.ParseLevels(c.LogLevels); err == nil {
+		c.packageLoggers().SetLevels(levels)
+	}
+}
+
+// PackageLogger returns the logger of pkg, whose level can be set apart from
+// the others with LogLevels. It writes through the embedded Logger.
+func (c *Config) PackageLogger(pkg string) *logrus.Entry {
+	return c.packageLoggers().For(pkg)
+}
+
+func (c *Config) packageLoggers() *logging.Loggers {
+	if c.loggers == nil {
+		c.loggers = logging.NewLoggers(c.Logger)
 	}
+	return c.loggers
 }
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// env serves vars as the environment. Unless vars say otherwise, the gateway
+// runs with auth_disabled, since it refuses to start without JWT keys.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		if !ok && name == EnvPrefix+"AUTH_DISABLED" {
+			return "true", true
+		}
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(AllComponents, nil, env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.UserServicePort != ":50051" || cfg.GatewayServicePort != ":50052" {
+		t.Errorf("ports = %q, %q; want :50051, :50052", cfg.UserServicePort, cfg.GatewayServicePort)
+	}
+	if cfg.UserServiceAddr != "" {
+		t.Errorf("UserServiceAddr = %q, want empty to dial the User service listener", cfg.UserServiceAddr)
+	}
+	if cfg.StoreDriver != "memory" || cfg.VerificationTokenTTL != 24*time.Hour {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+
+	gw, err := load(GatewayComponent, nil, env(nil))
+	if err != nil {
+		t.Fatalf("load gateway failed: %v", err)
+	}
+	if gw.UserServiceAddr != "localhost:50051" {
+		t.Errorf("gateway UserServiceAddr = %q, want localhost:50051", gw.UserServiceAddr)
+	}
+
+	// The binaries' metrics endpoints must not clash on one host
+	for _, tt := range []struct {
+		component Component
+		want      string
+	}{
+		{AllComponents, ":9090"},
+		{UserComponent, ":9091"},
+		{GatewayComponent, ":9092"},
+	} {
+		cfg, err := load(tt.component, nil, env(nil))
+		if err != nil {
+			t.Fatalf("load %v failed: %v", tt.component, err)
+		}
+		if cfg.MetricsPort != tt.want {
+			t.Errorf("%v MetricsPort = %q, want %s", tt.component, cfg.MetricsPort, tt.want)
+		}
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `
+user_service_port: ":6001"
+gateway_service_port: ":6002"
+log_level: warn
+store_snapshot_every: 10
+store_truncate_corrupt_log: true
+verification_token_ttl: 1h
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			content: `{
+  "user_service_port": ":6001",
+  "gateway_service_port": ":6002",
+  "log_level": "warn",
+  "store_snapshot_every": 10,
+  "store_truncate_corrupt_log": true,
+  "verification_token_ttl": "1h"
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			vars := map[string]string{
+				ConfigFileEnv:                    path,
+				"GRPC_DEMO_GATEWAY_SERVICE_PORT": ":7002",
+				"GRPC_DEMO_LOG_LEVEL":            "debug",
+			}
+			cfg, err := load(AllComponents, []string{"-log-level", "error"}, env(vars))
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+
+			if cfg.UserServicePort != ":6001" {
+				t.Errorf("UserServicePort = %q, want :6001 from file", cfg.UserServicePort)
+			}
+			if cfg.GatewayServicePort != ":7002" {
+				t.Errorf("GatewayServicePort = %q, want :7002 from env", cfg.GatewayServicePort)
+			}
+			if cfg.LogLevel != "error" || cfg.Level != logrus.ErrorLevel {
+				t.Errorf("LogLevel = %q (%v), want error from flag", cfg.LogLevel, cfg.Level)
+			}
+			if cfg.UserServiceAddr != "" {
+				t.Errorf("UserServiceAddr = %q, want empty, independent of user_service_port", cfg.UserServiceAddr)
+			}
+			if cfg.StoreSnapshotEvery != 10 || !cfg.StoreTruncateCorruptLog || cfg.VerificationTokenTTL != time.Hour {
+				t.Errorf("typed file values not applied: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoad_ConfigFlagOverridesEnv(t *testing.T) {
+	fromFlag := writeFile(t, "flag.yaml", `store_dsn: from-flag`)
+	fromEnv := writeFile(t, "env.yaml", `store_dsn: from-env`)
+
+	cfg, err := load(AllComponents, []string{"-config", fromFlag}, env(map[string]string{ConfigFileEnv: fromEnv}))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.StoreDSN != "from-flag" {
+		t.Errorf("StoreDSN = %q, want from-flag", cfg.StoreDSN)
+	}
+}
+
+func TestLoad_BoolFlag(t *testing.T) {
+	cfg, err := load(AllComponents, []string{"-store-truncate-corrupt-log"}, env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !cfg.StoreTruncateCorruptLog {
+		t.Error("StoreTruncateCorruptLog = false, want true")
+	}
+}
+
+func TestLoad_ReportsEveryInvalidKey(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+store_driver: file
+gateway_error_details: verbose
+colour: blue
+store_dsn: [a, b]
+`)
+	vars := map[string]string{
+		"GRPC_DEMO_STORE_SNAPSHOT_EVERY":   "many",
+		"GRPC_DEMO_VERIFICATION_TOKEN_TTL": "-1h",
+	}
+	_, err := load(AllComponents, []string{"-config", path, "-log-level", "loud", "-user-service-port", "50051"}, env(vars))
+
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	var keys []string
+	for _, p := range cerr.Problems {
+		keys = append(keys, p.Key)
+	}
+	want := []string{
+		"colour",
+		"store_dsn",
+		"store_snapshot_every",
+		"user_service_port",
+		"log_level",
+		"store_path",
+		"gateway_error_details",
+		"verification_token_ttl",
+	}
+	for _, k := range want {
+		if !slices.Contains(keys, k) {
+			t.Errorf("problems %v missing key %s", keys, k)
+		}
+	}
+	if len(keys) != len(want) {
+		t.Errorf("got %d problems %v, want %d", len(keys), keys, len(want))
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	if _, err := load(AllComponents, []string{"-h"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: expected flag.ErrHelp, got %v", err)
+	}
+	if _, err := load(AllComponents, []string{"-no-such-flag"}, env(nil)); err == nil {
+		t.Error("unknown flag: expected error")
+	}
+	if _, err := load(AllComponents, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil)); err == nil {
+		t.Error("missing file: expected error")
+	}
+	if _, err := load(AllComponents, []string{"-config", writeFile(t, "config.toml", "")}, env(nil)); err == nil {
+		t.Error("unsupported extension: expected error")
+	}
+}
+
+func TestLoad_Components(t *testing.T) {
+	path := writeFile(t, "shared.yaml", `
+user_service_port: ":6001"
+store_driver: sql
+store_dsn: "file:users.db"
+gateway_service_port: ":6001"
+user_service_addr: "users.internal:6001"
+`)
+	vars := map[string]string{
+		"GRPC_DEMO_UPSTREAM_TIMEOUT":       "not-a-duration",
+		"GRPC_DEMO_VERIFICATION_TOKEN_TTL": "2h",
+	}
+
+	user, err := load(UserComponent, []string{"-config", path}, env(vars))
+	if err != nil {
+		t.Fatalf("user: load failed: %v", err)
+	}
+	if user.StoreDriver != "sql" || user.VerificationTokenTTL != 2*time.Hour {
+		t.Errorf("user: own keys not applied: %+v", user)
+	}
+	if user.UserServiceAddr != "localhost:50051" {
+		t.Errorf("user: gateway key applied: UserServiceAddr = %q", user.UserServiceAddr)
+	}
+
+	vars["GRPC_DEMO_UPSTREAM_TIMEOUT"] = "3s"
+	gw, err := load(GatewayComponent, []string{"-config", path}, env(vars))
+	if err != nil {
+		t.Fatalf("gateway: load failed: %v", err)
+	}
+	if gw.UserServiceAddr != "users.internal:6001" || gw.GatewayServicePort != ":6001" || gw.UpstreamTimeout != 3*time.Second {
+		t.Errorf("gateway: own keys not applied: %+v", gw)
+	}
+	if gw.StoreDriver != "memory" || gw.VerificationTokenTTL != 24*time.Hour {
+		t.Errorf("gateway: user keys applied: %+v", gw)
+	}
+	if _, err := load(GatewayComponent, []string{"-store-driver", "sql"}, env(nil)); err == nil {
+		t.Error("gateway: expected -store-driver to be an unknown flag")
+	}
+
+	// The same file is invalid for the all-in-one binary, which runs both on one host
+	_, err = load(AllComponents, []string{"-config", path}, env(nil))
+	var cerr *Error
+	if !errors.As(err, &cerr) || len(cerr.Problems) != 1 || cerr.Problems[0].Key != "gateway_service_port" {
+		t.Errorf("all: expected a gateway_service_port clash, got %v", err)
+	}
+
+	// Only the gateway needs JWT keys, and it refuses to start without them
+	noAuth := env(map[string]string{EnvPrefix + "AUTH_DISABLED": "false"})
+	if _, err := load(UserComponent, nil, noAuth); err != nil {
+		t.Errorf("user: load without JWT keys failed: %v", err)
+	}
+	_, err = load(GatewayComponent, nil, noAuth)
+	if !errors.As(err, &cerr) || len(cerr.Problems) != 1 || cerr.Problems[0].Key != "jwt_hs256_secret" {
+		t.Errorf("gateway: expected jwt_hs256_secret to be required, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantKey string
+	}{
+		{name: "defaults are valid", modify: func(*Config) {}},
+		{name: "file store with path", modify: func(c *Config) { c.StoreDriver, c.StorePath = "file", "/tmp/users" }},
+		{name: "sql store without DSN", modify: func(c *Config) { c.StoreDriver = "sql" }, wantKey: "store_dsn"},
+		{name: "unknown store driver", modify: func(c *Config) { c.StoreDriver = "redis" }, wantKey: "store_driver"},
+		{name: "same ports", modify: func(c *Config) { c.GatewayServicePort = c.UserServicePort }, wantKey: "gateway_service_port"},
+		{name: "port out of range", modify: func(c *Config) { c.UserServicePort = ":70000" }, wantKey: "user_service_port"},
+		{name: "upstream without host", modify: func(c *Config) { c.UserServiceAddr = ":50051" }, wantKey: "user_service_addr"},
+		{name: "upstream on port zero", modify: func(c *Config) { c.UserServiceAddr = "localhost:0" }, wantKey: "user_service_addr"},
+		{name: "upstream is own listener", modify: func(c *Config) { c.UserServiceAddr = "" }},
+		{name: "gateway without upstream", modify: func(c *Config) { c.components, c.UserServiceAddr = GatewayComponent, "" }, wantKey: "user_service_addr"},
+		{name: "negative snapshot interval", modify: func(c *Config) { c.StoreSnapshotEvery = -1 }, wantKey: "store_snapshot_every"},
+		{name: "negative password hash concurrency", modify: func(c *Config) { c.PasswordHashConcurrency = -1 }, wantKey: "password_hash_concurrency"},
+		{name: "json logs with package levels", modify: func(c *Config) { c.LogFormat, c.LogLevels = "json", "user=debug, gateway=warn" }},
+		{name: "unknown log format", modify: func(c *Config) { c.LogFormat = "xml" }, wantKey: "log_format"},
+		{name: "package level without package", modify: func(c *Config) { c.LogLevels = "=debug" }, wantKey: "log_levels"},
+		{name: "unknown package level", modify: func(c *Config) { c.LogLevels = "user=loud" }, wantKey: "log_levels"},
+		{name: "otlp tracing", modify: func(c *Config) { c.TracingExporter = "otlp" }},
+		{name: "unknown tracing exporter", modify: func(c *Config) { c.TracingExporter = "jaeger" }, wantKey: "tracing_exporter"},
+		{name: "no upstream health checks", modify: func(c *Config) { c.UpstreamHealthInterval = 0 }, wantKey: "upstream_health_interval"},
+		{name: "metrics disabled", modify: func(c *Config) { c.MetricsPort = "" }},
+		{name: "metrics on a service port", modify: func(c *Config) { c.MetricsPort = c.GatewayServicePort }, wantKey: "metrics_port"},
+		{name: "mutual TLS", modify: func(c *Config) {
+			c.TLSCA, c.UserTLSCert, c.UserTLSKey = "ca.pem", "user.pem", "user-key.pem"
+			c.GatewayClientTLSCert, c.GatewayClientTLSKey = "client.pem", "client-key.pem"
+		}},
+		{name: "TLS cert without key", modify: func(c *Config) { c.GatewayTLSCert = "gateway.pem" }, wantKey: "gateway_tls_key"},
+		{name: "TLS key without cert", modify: func(c *Config) { c.UserTLSKey = "user-key.pem" }, wantKey: "user_tls_cert"},
+		{name: "mutual TLS without CA", modify: func(c *Config) { c.UserTLSCert, c.UserTLSKey = "user.pem", "user-key.pem" }, wantKey: "user_tls_cert"},
+		{name: "CA without gateway client cert", modify: func(c *Config) {
+			c.TLSCA, c.UserTLSCert, c.UserTLSKey = "ca.pem", "user.pem", "user-key.pem"
+		}, wantKey: "gateway_client_tls_cert"},
+		{name: "gateway CA without client cert", modify: func(c *Config) { c.components, c.TLSCA = GatewayComponent, "ca.pem" }, wantKey: "gateway_client_tls_cert"},
+		{name: "User service CA", modify: func(c *Config) {
+			c.components, c.TLSCA, c.UserTLSCert, c.UserTLSKey = UserComponent, "ca.pem", "user.pem", "user-key.pem"
+		}},
+		{name: "peer allowlist", modify: func(c *Config) {
+			c.components, c.TLSCA, c.UserTLSCert, c.UserTLSKey = UserComponent, "ca.pem", "user.pem", "user-key.pem"
+			c.UserPeerAllowlist = "*=spiffe://grpc-demo.local/gateway,ListUsers=reporting"
+		}},
+		{name: "bad peer allowlist", modify: func(c *Config) {
+			c.components, c.TLSCA, c.UserTLSCert, c.UserTLSKey = UserComponent, "ca.pem", "user.pem", "user-key.pem"
+			c.UserPeerAllowlist = "CreateUser"
+		}, wantKey: "user_peer_allowlist"},
+		{name: "peer allowlist without TLS", modify: func(c *Config) { c.UserPeerAllowlist = "*=gateway" }, wantKey: "user_peer_allowlist"},
+		{name: "JWKS instead of a secret", modify: func(c *Config) { c.JWTHS256Secret, c.JWTJWKSPath = "", "jwks.json" }},
+		{name: "no JWT keys", modify: func(c *Config) { c.JWTHS256Secret = "" }, wantKey: "jwt_hs256_secret"},
+		{name: "auth disabled", modify: func(c *Config) { c.JWTHS256Secret, c.AuthDisabled = "", true }},
+		{name: "auth disabled with keys", modify: func(c *Config) { c.AuthDisabled = true }, wantKey: "auth_disabled"},
+		{name: "short JWT secret", modify: func(c *Config) { c.JWTHS256Secret = "secret" }, wantKey: "jwt_hs256_secret"},
+		{name: "no admin scope", modify: func(c *Config) { c.JWTAdminScope = "" }, wantKey: "jwt_admin_scope"},
+		{name: "no access token TTL", modify: func(c *Config) { c.AccessTokenTTL = 0 }, wantKey: "access_token_ttl"},
+		{name: "access tokens outlive refresh tokens", modify: func(c *Config) { c.RefreshTokenTTL = time.Minute }, wantKey: "access_token_ttl"},
+		{name: "no shutdown drain", modify: func(c *Config) { c.ShutdownDrain = 0 }},
+		{name: "negative shutdown drain", modify: func(c *Config) { c.ShutdownDrain = -time.Second }, wantKey: "shutdown_drain"},
+		{name: "no shutdown timeout", modify: func(c *Config) { c.ShutdownTimeout = 0 }, wantKey: "shutdown_timeout"},
+		{name: "otlp endpoint without scheme", modify: func(c *Config) { c.TracingExporter, c.TracingEndpoint = "otlp", "collector:4317" }, wantKey: "tracing_endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := New(":50051", ":50052")
+			cfg.JWTHS256Secret = strings.Repeat("k", 32)
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var cerr *Error
+			if !errors.As(err, &cerr) || len(cerr.Problems) != 1 || cerr.Problems[0].Key != tt.wantKey {
+				t.Errorf("expected one problem for %s, got %v", tt.wantKey, err)
+			}
+		})
+	}
+}
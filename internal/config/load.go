@@ -0,0 +1,431 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load, e.g.
+// GRPC_DEMO_USER_SERVICE_PORT for the user_service_port key
+const EnvPrefix = "GRPC_DEMO_"
+
+// ConfigFileEnv names the config file when the -config flag is not given
+const ConfigFileEnv = EnvPrefix + "CONFIG"
+
+// option is one configuration key. Every layer reads the same keys: the file
+// uses the key itself, the environment EnvPrefix plus the key in upper case,
+// and flags the key with dashes instead of underscores.
+type option struct {
+	key    string
+	usage  string
+	isBool bool
+	set    func(c *Config, v string) error
+	value  func(c *Config) any
+	// reloadable keys may change in a running process; see Config.Reload
+	reloadable bool
+	components Component
+}
+
+// reloadable marks o as safe to change without a restart
+func reloadable(o option) option {
+	o.reloadable = true
+	return o
+}
+
+func stringOption(key, usage string, field func(*Config) *string) option {
+	return option{key: key, usage: usage, value: func(c *Config) any { return *field(c) }, set: func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func intOption(key, usage string, field func(*Config) *int) option {
+	return option{key: key, usage: usage, value: func(c *Config) any { return *field(c) }, set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolOption(key, usage string, field func(*Config) *bool) option {
+	return option{key: key, usage: usage, isBool: true, value: func(c *Config) any { return *field(c) }, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationOption(key, usage string, field func(*Config) *time.Duration) option {
+	return option{key: key, usage: usage, value: func(c *Config) any { return *field(c) }, set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 24h", v)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+// Component is a set of services run by one binary. It decides which keys the
+// binary accepts: keys for services it does not run are ignored in the config
+// file, not read from the environment and not offered as flags.
+type Component int
+
+const (
+	UserComponent Component = 1 << iota
+	GatewayComponent
+
+	// AllComponents runs every service in one process
+	AllComponents = UserComponent | GatewayComponent
+)
+
+// String names the binary running c, as shown in its usage text
+func (c Component) String() string {
+	switch c {
+	case UserComponent:
+		return "user"
+	case GatewayComponent:
+		return "gateway"
+	default:
+		return "grpc-demo"
+	}
+}
+
+// scope assigns opts to the services in c
+func scope(c Component, opts ...option) []option {
+	for i := range opts {
+		opts[i].components = c
+	}
+	return opts
+}
+
+var options = slices.Concat(
+	scope(AllComponents,
+		reloadable(stringOption("log_level", "log level: trace, debug, info, warn, error, fatal or panic", func(c *Config) *string { return &c.LogLevel })),
+		reloadable(stringOption("log_format", `log output: "text" or "json"`, func(c *Config) *string { return &c.LogFormat })),
+		reloadable(stringOption("log_levels", "per-package log levels overriding log_level, e.g. user=debug,gateway=warn", func(c *Config) *string { return &c.LogLevels })),
+		stringOption("tracing_exporter", `where trace spans are sent: "none" or "otlp"`, func(c *Config) *string { return &c.TracingExporter }),
+		stringOption("tracing_endpoint", "OTLP/gRPC collector URL; http:// connects without TLS", func(c *Config) *string { return &c.TracingEndpoint }),
+		stringOption("metrics_port", "listen address of the Prometheus /metrics endpoint, by default :9091 for user, :9092 for gateway and :9090 for grpc-demo (empty disables it)", func(c *Config) *string { return &c.MetricsPort }),
+		reloadable(durationOption("shutdown_drain", "how long to keep serving after reporting NOT_SERVING on shutdown", func(c *Config) *time.Duration { return &c.ShutdownDrain })),
+		reloadable(durationOption("shutdown_timeout", "how long shutdown waits for in-flight calls before stopping forcibly", func(c *Config) *time.Duration { return &c.ShutdownTimeout })),
+		stringOption("tls_ca", "PEM CA bundle verifying peers on the gateway to User service hop (empty for plaintext)", func(c *Config) *string { return &c.TLSCA }),
+	),
+	scope(UserComponent,
+		stringOption("user_service_port", "listen address of the User service", func(c *Config) *string { return &c.UserServicePort }),
+		stringOption("store_driver", `user store: "memory", "file" or "sql"`, func(c *Config) *string { return &c.StoreDriver }),
+		stringOption("store_path", "directory of the file store", func(c *Config) *string { return &c.StorePath }),
+		intOption("store_snapshot_every", "compact the file store log after this many records (0 uses the default)", func(c *Config) *int { return &c.StoreSnapshotEvery }),
+		boolOption("store_truncate_corrupt_log", "discard a corrupt file store log tail on startup", func(c *Config) *bool { return &c.StoreTruncateCorruptLog }),
+		stringOption("store_sql_driver", "database/sql driver of the sql store", func(c *Config) *string { return &c.StoreSQLDriver }),
+		stringOption("store_dsn", "data source name of the sql store", func(c *Config) *string { return &c.StoreDSN }),
+		stringOption("page_token_secret", "HMAC key for ListUsers page tokens, shared by all replicas", func(c *Config) *string { return &c.PageTokenSecret }),
+		stringOption("mail_outbox_path", "file outbound email is appended to; the log never shows recipients or bodies (empty only logs that mail was sent)", func(c *Config) *string { return &c.MailOutboxPath }),
+		intOption("password_hash_concurrency", "password hashes computed at once, each taking 64 MiB; more are refused (0 allows one per CPU)", func(c *Config) *int { return &c.PasswordHashConcurrency }),
+		reloadable(durationOption("verification_token_ttl", "lifetime of email verification tokens", func(c *Config) *time.Duration { return &c.VerificationTokenTTL })),
+		stringOption("user_tls_cert", "PEM certificate of the User service; enables mutual TLS", func(c *Config) *string { return &c.UserTLSCert }),
+		stringOption("user_tls_key", "PEM private key of user_tls_cert", func(c *Config) *string { return &c.UserTLSKey }),
+		reloadable(stringOption("user_peer_allowlist", `client certificate identities allowed per User service method, e.g. "*=spiffe://grpc-demo.local/gateway,ListUsers=spiffe://grpc-demo.local/gateway|reporting"`, func(c *Config) *string { return &c.UserPeerAllowlist })),
+	),
+	scope(GatewayComponent,
+		stringOption("gateway_service_port", "listen address of the Gateway service", func(c *Config) *string { return &c.GatewayServicePort }),
+		stringOption("user_service_addr", "host:port the gateway dials to reach the User service (grpc-demo defaults to its own User service listener)", func(c *Config) *string { return &c.UserServiceAddr }),
+		reloadable(stringOption("gateway_error_details", `upstream error details the gateway forwards: "strip", "public" or "all"`, func(c *Config) *string { return &c.GatewayErrorDetails })),
+		reloadable(durationOption("upstream_timeout", "deadline for each gateway call to the User service (0 for none)", func(c *Config) *time.Duration { return &c.UpstreamTimeout })),
+		reloadable(durationOption("upstream_health_interval", "how often the gateway checks the User service's health", func(c *Config) *time.Duration { return &c.UpstreamHealthInterval })),
+		stringOption("gateway_tls_cert", "PEM certificate of the Gateway's public listener; enables TLS", func(c *Config) *string { return &c.GatewayTLSCert }),
+		stringOption("gateway_tls_key", "PEM private key of gateway_tls_cert", func(c *Config) *string { return &c.GatewayTLSKey }),
+		stringOption("gateway_client_tls_cert", "PEM client certificate the Gateway presents to the User service; required when tls_ca is set", func(c *Config) *string { return &c.GatewayClientTLSCert }),
+		stringOption("gateway_client_tls_key", "PEM private key of gateway_client_tls_cert", func(c *Config) *string { return &c.GatewayClientTLSKey }),
+		stringOption("jwt_hs256_secret", "secret verifying HS256 bearer tokens; it or jwt_jwks_path is required unless auth_disabled is set", func(c *Config) *string { return &c.JWTHS256Secret }),
+		stringOption("jwt_jwks_path", "local JWKS file verifying RS256 and ES256 bearer tokens", func(c *Config) *string { return &c.JWTJWKSPath }),
+		boolOption("auth_disabled", "run without JWT keys, letting every caller act on any account (development only)", func(c *Config) *bool { return &c.AuthDisabled }),
+		stringOption("jwt_issuer", "required iss claim of bearer tokens (empty accepts any)", func(c *Config) *string { return &c.JWTIssuer }),
+		stringOption("jwt_audience", "required aud claim of bearer tokens (empty accepts any)", func(c *Config) *string { return &c.JWTAudience }),
+		reloadable(stringOption("jwt_admin_scope", "bearer token scope that may act on any user's account", func(c *Config) *string { return &c.JWTAdminScope })),
+		reloadable(durationOption("access_token_ttl", "lifetime of the access tokens Login and RefreshToken issue", func(c *Config) *time.Duration { return &c.AccessTokenTTL })),
+		reloadable(durationOption("refresh_token_ttl", "how long an unused refresh token stays redeemable; sessions live in one gateway's memory, so Login needs a single gateway instance", func(c *Config) *time.Duration { return &c.RefreshTokenTTL })),
+	),
+)
+
+// in reports whether o applies to a binary running the services in c
+func (o option) in(c Component) bool {
+	return o.components&c != 0
+}
+
+func lookupOption(key string) (option, bool) {
+	for _, o := range options {
+		if o.key == key {
+			return o, true
+		}
+	}
+	return option{}, false
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// Problem is one invalid configuration key
+type Problem struct {
+	Key     string
+	Source  string // Where the value came from, e.g. "env GRPC_DEMO_LOG_LEVEL"; empty for the merged result
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Source == "" {
+		return fmt.Sprintf("%s: %s", p.Key, p.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", p.Key, p.Source, p.Message)
+}
+
+// Error lists every problem found while loading or validating a Config
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// problems collects Problems and turns them into an *Error
+type problems []Problem
+
+func (ps *problems) add(key, source, format string, args ...any) {
+	*ps = append(*ps, Problem{Key: key, Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+func (ps problems) err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return &Error{Problems: ps}
+}
+
+// Load builds a Config from, in increasing order of precedence, the defaults
+// of New, a YAML or JSON file, EnvPrefix environment variables and the
+// command-line flags in args (usually os.Args[1:]), reading only the keys of
+// the services in component. The file is named by the -config flag or the
+// ConfigFileEnv variable. The result is validated, and every invalid key
+// across all layers is reported in a single *Error. For -h or -help the error
+// wraps flag.ErrHelp and carries the usage text.
+func Load(component Component, args []string) (*Config, error) {
+	return load(component, args, os.LookupEnv)
+}
+
+// flagValue is a flag seen on the command line, applied after the other layers
+type flagValue struct {
+	key, name, value string
+}
+
+func load(component Component, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg, path, err := parse(component, args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.applyLogging()
+	cfg.reload = &reloader{component: component, args: args, lookupEnv: lookupEnv, path: path}
+	cfg.reload.current.Store(cfg)
+	return cfg, nil
+}
+
+// parse runs every layer and validates the result. It also returns the
+// config file path, if any.
+func parse(component Component, args []string, lookupEnv func(string) (string, bool)) (*Config, string, error) {
+	cfg := New(":50051", ":50052")
+	cfg.components = component
+	cfg.componentDefaults()
+	var ps problems
+
+	fs := flag.NewFlagSet(component.String(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", "", "YAML or JSON config file (env "+ConfigFileEnv+")")
+	var flags []flagValue
+	for _, o := range options {
+		if !o.in(component) {
+			continue
+		}
+		name := flagName(o.key)
+		record := func(v string) error {
+			flags = append(flags, flagValue{key: o.key, name: name, value: v})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (env %s)", o.usage, envName(o.key))
+		if o.isBool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, "", fmt.Errorf("%w\nusage of %s:\n%s", err, fs.Name(), usage(fs))
+		}
+		return nil, "", err
+	}
+
+	// File
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv(ConfigFileEnv)
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		source := "file " + path
+		for _, key := range sortedKeys(values) {
+			o, ok := lookupOption(key)
+			if !ok {
+				ps.add(key, source, "unknown key")
+				continue
+			}
+			if !o.in(component) {
+				continue // Meant for another service sharing the file
+			}
+			v, err := scalarString(values[key])
+			if err == nil {
+				err = o.set(cfg, v)
+			}
+			if err != nil {
+				ps.add(key, source, "%v", err)
+			}
+		}
+	}
+
+	// Environment
+	for _, o := range options {
+		if !o.in(component) {
+			continue
+		}
+		name := envName(o.key)
+		v, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := o.set(cfg, v); err != nil {
+			ps.add(o.key, "env "+name, "%v", err)
+		}
+	}
+
+	// Flags
+	for _, f := range flags {
+		o, _ := lookupOption(f.key)
+		if err := o.set(cfg, f.value); err != nil {
+			ps.add(f.key, "flag -"+f.name, "%v", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		var verr *Error
+		if !errors.As(err, &verr) {
+			return nil, "", err
+		}
+		ps = append(ps, verr.Problems...)
+	}
+	if err := ps.err(); err != nil {
+		return nil, "", err
+	}
+	return cfg, path, nil
+}
+
+// componentDefaults replaces the defaults of New that depend on which services
+// c runs. The separate binaries get their own metrics ports so they can share
+// a host, and the all-in-one binary dials its own User service listener.
+func (c *Config) componentDefaults() {
+	switch c.components {
+	case UserComponent:
+		c.MetricsPort = ":9091"
+	case GatewayComponent:
+		c.MetricsPort = ":9092"
+	case AllComponents:
+		c.UserServiceAddr = ""
+	}
+}
+
+// readFile decodes a flat map of keys from a .json, .yaml or .yml file
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	values := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (want .json, .yaml or .yml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// scalarString renders a decoded file value the way it would be written in
+// an environment variable or flag
+func scalarString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64, json.Number:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("must be a string, number or boolean, not %T", v)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func usage(fs *flag.FlagSet) string {
+	var b strings.Builder
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+	return b.String()
+}
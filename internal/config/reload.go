@@ -0,0 +1,197 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Subscriber receives the configuration produced by each successful reload.
+// It must not block or call Reload or Subscribe; the reloading goroutine
+// waits for every subscriber.
+type Subscriber func(next *Config)
+
+// reloader re-runs the layers a Config was loaded from and publishes the result
+type reloader struct {
+	component Component
+	args      []string
+	lookupEnv func(string) (string, bool)
+	path      string // Config file, if any
+
+	current atomic.Pointer[Config]
+
+	mu     sync.Mutex // Serializes reloads and guards subs
+	nextID int
+	subs   map[int]Subscriber
+}
+
+// ErrNotReloadable is returned by Reload for a Config not created by Load
+var ErrNotReloadable = errors.New("configuration was not created by Load and cannot be reloaded")
+
+// Subscribe registers fn to receive every reloaded configuration and returns
+// a function that cancels the subscription. It is a no-op for a Config not
+// created by Load, which never reloads.
+func (c *Config) Subscribe(fn Subscriber) (unsubscribe func()) {
+	r := c.reload
+	if r == nil {
+		return func() {}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs == nil {
+		r.subs = make(map[int]Subscriber)
+	}
+	id := r.nextID
+	r.nextID++
+	r.subs[id] = fn
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subs, id)
+	}
+}
+
+// Reload reads the file, environment and flags again and, if the result is
+// valid and changes only reloadable keys, applies the new logging settings and
+// publishes the new Config to subscribers. Otherwise nothing changes and the
+// rejection is logged and returned. c itself is never modified.
+func (c *Config) Reload() error {
+	r := c.reload
+	if r == nil {
+		return ErrNotReloadable
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, _, err := parse(r.component, r.args, r.lookupEnv)
+	if err == nil {
+		err = checkReloadable(r.current.Load(), next)
+	}
+	log := c.PackageLogger("config")
+	if err != nil {
+		log.WithError(err).Error("Reload rejected, keeping the current configuration")
+		return err
+	}
+
+	changed := changedKeys(r.current.Load(), next)
+	if len(changed) == 0 {
+		log.Info("Reloaded configuration; nothing changed")
+		return nil
+	}
+
+	next.Logger = c.Logger
+	next.reload = r
+	next.loggers = c.packageLoggers()
+	next.applyLogging()
+	r.current.Store(next)
+	for _, fn := range r.subs {
+		fn(next)
+	}
+	log.WithField("changed", strings.Join(changed, ",")).Info("Reloaded configuration")
+	return nil
+}
+
+// Current returns the most recently published configuration
+func (c *Config) Current() *Config {
+	if c.reload == nil {
+		return c
+	}
+	return c.reload.current.Load()
+}
+
+// checkReloadable rejects changes to keys that only take effect at startup
+func checkReloadable(cur, next *Config) error {
+	var ps problems
+	for _, o := range options {
+		if o.in(next.components) && !o.reloadable && o.value(cur) != o.value(next) {
+			ps.add(o.key, "", "cannot change from %v to %v without a restart", display(o, cur), display(o, next))
+		}
+	}
+	return ps.err()
+}
+
+func changedKeys(cur, next *Config) []string {
+	var keys []string
+	for _, o := range options {
+		if o.in(next.components) && o.value(cur) != o.value(next) {
+			keys = append(keys, o.key)
+		}
+	}
+	return keys
+}
+
+// secretKeys are the options whose values never appear in logs or errors.
+// A DSN may embed a database password.
+var secretKeys = []string{"page_token_secret", "jwt_hs256_secret", "store_dsn"}
+
+// display renders a key's value for logs, hiding secrets
+func display(o option, c *Config) string {
+	if slices.Contains(secretKeys, o.key) {
+		return "<redacted>"
+	}
+	return fmt.Sprintf("%q", fmt.Sprint(o.value(c)))
+}
+
+// watchDebounce coalesces the burst of events editors produce when saving
+const watchDebounce = 100 * time.Millisecond
+
+// Watch reloads c whenever its config file changes, until ctx is done. It
+// watches the file's directory so that editors and tools that replace the
+// file by renaming still trigger a reload. Without a config file it returns
+// immediately.
+func (c *Config) Watch(ctx context.Context) error {
+	r := c.reload
+	if r == nil || r.path == "" {
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watch config file: %w", err)
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(r.path)); err != nil {
+		return fmt.Errorf("watch config file: %w", err)
+	}
+	name := filepath.Clean(r.path)
+
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(ev.Name) != name || ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(watchDebounce)
+			} else {
+				timer.Reset(watchDebounce)
+			}
+			fire = timer.C
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			c.PackageLogger("config").WithError(err).Warn("Config file watcher error")
+		case <-fire:
+			fire = nil
+			c.Reload()
+		}
+	}
+}
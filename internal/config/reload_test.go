@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loadFile loads a Config whose only layer is a YAML file with content
+func loadFile(t *testing.T, content string) (*Config, string) {
+	t.Helper()
+	path := writeFile(t, "config.yaml", content)
+	cfg, err := load(AllComponents, []string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	cfg.SetOutput(io.Discard)
+	return cfg, path
+}
+
+func rewrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantErr   bool
+		wantKeys  []string // Problems reported on rejection
+		wantLevel logrus.Level
+		wantTTL   time.Duration
+	}{
+		{
+			name:      "reloadable keys apply",
+			content:   "log_level: debug\nverification_token_ttl: 2h\n",
+			wantLevel: logrus.DebugLevel,
+			wantTTL:   2 * time.Hour,
+		},
+		{
+			name:      "port change is rejected",
+			content:   "log_level: debug\nuser_service_port: \":6001\"\n",
+			wantErr:   true,
+			wantKeys:  []string{"user_service_port"},
+			wantLevel: logrus.InfoLevel,
+			wantTTL:   time.Hour,
+		},
+		{
+			name:      "invalid config is rejected",
+			content:   "log_level: loud\nverification_token_ttl: 0s\n",
+			wantErr:   true,
+			wantKeys:  []string{"log_level", "verification_token_ttl"},
+			wantLevel: logrus.InfoLevel,
+			wantTTL:   time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, path := loadFile(t, "log_level: info\nverification_token_ttl: 1h\n")
+			var published []*Config
+			cfg.Subscribe(func(next *Config) { published = append(published, next) })
+
+			rewrite(t, path, tt.content)
+			err := cfg.Reload()
+
+			if tt.wantErr {
+				var cerr *Error
+				if !errors.As(err, &cerr) {
+					t.Fatalf("expected *Error, got %v", err)
+				}
+				if len(cerr.Problems) != len(tt.wantKeys) {
+					t.Fatalf("problems = %v, want keys %v", cerr.Problems, tt.wantKeys)
+				}
+				for i, k := range tt.wantKeys {
+					if cerr.Problems[i].Key != k {
+						t.Errorf("problem %d key = %s, want %s", i, cerr.Problems[i].Key, k)
+					}
+				}
+				if len(published) != 0 {
+					t.Errorf("rejected reload published %d configs", len(published))
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Reload failed: %v", err)
+				}
+				if len(published) != 1 || published[0] != cfg.Current() {
+					t.Fatalf("published %v, want the new current config once", published)
+				}
+			}
+
+			if cfg.Level != tt.wantLevel {
+				t.Errorf("logger level = %v, want %v", cfg.Level, tt.wantLevel)
+			}
+			if got := cfg.Current().VerificationTokenTTL; got != tt.wantTTL {
+				t.Errorf("current VerificationTokenTTL = %v, want %v", got, tt.wantTTL)
+			}
+			if cfg.VerificationTokenTTL != time.Hour {
+				t.Errorf("original config modified: VerificationTokenTTL = %v", cfg.VerificationTokenTTL)
+			}
+		})
+	}
+}
+
+func TestReload_RedactsSecrets(t *testing.T) {
+	cfg, path := loadFile(t, "store_dsn: postgres://app:old-pw@db/users\n")
+	var buf bytes.Buffer
+	cfg.SetOutput(&buf)
+
+	rewrite(t, path, "store_dsn: postgres://app:new-pw@db/users\n")
+	err := cfg.Reload()
+	if err == nil {
+		t.Fatal("expected the store_dsn change to be rejected")
+	}
+	for _, out := range []string{err.Error(), buf.String()} {
+		if strings.Contains(out, "old-pw") || strings.Contains(out, "new-pw") {
+			t.Errorf("output leaks the DSN: %s", out)
+		}
+	}
+	if !strings.Contains(buf.String(), "store_dsn") {
+		t.Errorf("log = %q, want the rejected key", buf.String())
+	}
+}
+
+func TestReload_Unsubscribe(t *testing.T) {
+	cfg, path := loadFile(t, "log_level: info\n")
+	calls := 0
+	unsubscribe := cfg.Subscribe(func(*Config) { calls++ })
+
+	rewrite(t, path, "log_level: warn\n")
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	unsubscribe()
+	rewrite(t, path, "log_level: error\n")
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("subscriber called %d times, want 1", calls)
+	}
+}
+
+func TestReload_NotLoaded(t *testing.T) {
+	cfg := New(":50051", ":50052")
+	if err := cfg.Reload(); !errors.Is(err, ErrNotReloadable) {
+		t.Errorf("expected ErrNotReloadable, got %v", err)
+	}
+	if cfg.Current() != cfg {
+		t.Error("Current should return the config itself")
+	}
+	cfg.Subscribe(func(*Config) {})() // must not panic
+}
+
+func TestWatch(t *testing.T) {
+	cfg, path := loadFile(t, "log_level: info\n")
+	published := make(chan *Config, 1)
+	cfg.Subscribe(func(next *Config) { published <- next })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cfg.Watch(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Watch returned %v", err)
+		}
+	}()
+
+	// Keep rewriting, slower than the debounce, until the watcher is up and notices
+	deadline := time.After(5 * time.Second)
+	tick := time.NewTicker(4 * watchDebounce)
+	defer tick.Stop()
+	for {
+		select {
+		case next := <-published:
+			if next.LogLevel != "debug" {
+				t.Errorf("published LogLevel = %q, want debug", next.LogLevel)
+			}
+			return
+		case <-tick.C:
+			rewrite(t, path, "log_level: debug\n")
+		case <-deadline:
+			t.Fatal("no reload after the config file changed")
+		}
+	}
+}
+
+func TestReload_Logging(t *testing.T) {
+	cfg, path := loadFile(t, "log_levels: user=debug\n")
+	user, gateway := cfg.PackageLogger("user"), cfg.PackageLogger("gateway")
+	if got := user.Logger.GetLevel(); got != logrus.DebugLevel {
+		t.Errorf("user level = %s, want debug", got)
+	}
+	if got := gateway.Logger.GetLevel(); got != logrus.InfoLevel {
+		t.Errorf("gateway level = %s, want info", got)
+	}
+
+	rewrite(t, path, "log_level: warn\nlog_levels: gateway=error\nlog_format: json\n")
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := user.Logger.GetLevel(); got != logrus.WarnLevel {
+		t.Errorf("user level after reload = %s, want warn", got)
+	}
+	if got := gateway.Logger.GetLevel(); got != logrus.ErrorLevel {
+		t.Errorf("gateway level after reload = %s, want error", got)
+	}
+	if _, ok := user.Logger.Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("user formatter after reload = %T, want JSON", user.Logger.Formatter)
+	}
+}
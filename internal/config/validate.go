@@ -0,0 +1,181 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mr1hm/grpc-demo/internal/authz"
+	"github.com/mr1hm/grpc-demo/internal/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Validate checks the fields of the services c was loaded for and reports all
+// problems at once as an *Error
+func (c *Config) Validate() error {
+	var ps problems
+
+	checkListenAddr(&ps, "user_service_port", c.UserServicePort)
+	checkListenAddr(&ps, "gateway_service_port", c.GatewayServicePort)
+	// Separate binaries may share a port on different hosts
+	if c.components == AllComponents && c.UserServicePort == c.GatewayServicePort && !ephemeral(c.UserServicePort) {
+		ps.add("gateway_service_port", "", "must differ from user_service_port %q", c.UserServicePort)
+	}
+	if c.MetricsPort != "" {
+		checkListenAddr(&ps, "metrics_port", c.MetricsPort)
+		for _, o := range []struct{ key, addr string }{
+			{"user_service_port", c.UserServicePort},
+			{"gateway_service_port", c.GatewayServicePort},
+		} {
+			if opt, _ := lookupOption(o.key); opt.in(c.components) && c.MetricsPort == o.addr && !ephemeral(o.addr) {
+				ps.add("metrics_port", "", "must differ from %s %q", o.key, o.addr)
+			}
+		}
+	}
+	if c.ShutdownDrain < 0 {
+		ps.add("shutdown_drain", "", "must not be negative, got %s", c.ShutdownDrain)
+	}
+	if c.ShutdownTimeout <= 0 {
+		ps.add("shutdown_timeout", "", "must be positive, got %s", c.ShutdownTimeout)
+	}
+	checkKeyPair(&ps, "user_tls", c.UserTLSCert, c.UserTLSKey)
+	checkKeyPair(&ps, "gateway_tls", c.GatewayTLSCert, c.GatewayTLSKey)
+	checkKeyPair(&ps, "gateway_client_tls", c.GatewayClientTLSCert, c.GatewayClientTLSKey)
+	// The internal hop is mutual TLS or plaintext, never one-sided
+	if c.TLSCA == "" {
+		if c.UserTLSCert != "" {
+			ps.add("user_tls_cert", "", "requires tls_ca to verify client certificates")
+		}
+		if c.GatewayClientTLSCert != "" {
+			ps.add("gateway_client_tls_cert", "", "requires tls_ca to verify the User service")
+		}
+	} else if c.GatewayClientTLSCert == "" {
+		ps.add("gateway_client_tls_cert", "", "required when tls_ca is set, since the User service then requires a client certificate")
+	}
+	if _, err := authz.ParseAllowlist(c.UserPeerAllowlist); err != nil {
+		ps.add("user_peer_allowlist", "", "%v", err)
+	} else if c.UserPeerAllowlist != "" && c.UserTLSCert == "" {
+		ps.add("user_peer_allowlist", "", "requires user_tls_cert to identify callers")
+	}
+	// Empty in the all-in-one binary means its own User service listener
+	if c.UserServiceAddr != "" || c.components != AllComponents {
+		if host, port, err := net.SplitHostPort(c.UserServiceAddr); err != nil || host == "" || !validPort(port) || port == "0" {
+			ps.add("user_service_addr", "", "%q is not a host:port address", c.UserServiceAddr)
+		}
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		ps.add("log_level", "", "%q is not a log level", c.LogLevel)
+	}
+	if _, err := logging.Formatter(c.LogFormat); err != nil {
+		ps.add("log_format", "", `%q is not "text" or "json"`, c.LogFormat)
+	}
+	if _, err := logging.ParseLevels(c.LogLevels); err != nil {
+		ps.add("log_levels", "", "%v", err)
+	}
+	switch c.TracingExporter {
+	case "none":
+	case "otlp":
+		if u, err := url.Parse(c.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			ps.add("tracing_endpoint", "", "%q is not an http:// or https:// URL", c.TracingEndpoint)
+		}
+	default:
+		ps.add("tracing_exporter", "", `%q is not one of "none" or "otlp"`, c.TracingExporter)
+	}
+
+	switch c.StoreDriver {
+	case "memory":
+	case "file":
+		if c.StorePath == "" {
+			ps.add("store_path", "", "required when store_driver is %q", c.StoreDriver)
+		}
+	case "sql":
+		if c.StoreSQLDriver == "" {
+			ps.add("store_sql_driver", "", "required when store_driver is %q", c.StoreDriver)
+		}
+		if c.StoreDSN == "" {
+			ps.add("store_dsn", "", "required when store_driver is %q", c.StoreDriver)
+		}
+	default:
+		ps.add("store_driver", "", `%q is not one of "memory", "file" or "sql"`, c.StoreDriver)
+	}
+	if c.StoreSnapshotEvery < 0 {
+		ps.add("store_snapshot_every", "", "must not be negative, got %d", c.StoreSnapshotEvery)
+	}
+	if c.PasswordHashConcurrency < 0 {
+		ps.add("password_hash_concurrency", "", "must not be negative, got %d", c.PasswordHashConcurrency)
+	}
+
+	if !slices.Contains([]string{"strip", "public", "all"}, c.GatewayErrorDetails) {
+		ps.add("gateway_error_details", "", `%q is not one of "strip", "public" or "all"`, c.GatewayErrorDetails)
+	}
+	if c.UpstreamTimeout < 0 {
+		ps.add("upstream_timeout", "", "must not be negative, got %s", c.UpstreamTimeout)
+	}
+	if c.UpstreamHealthInterval <= 0 {
+		ps.add("upstream_health_interval", "", "must be positive, got %s", c.UpstreamHealthInterval)
+	}
+	// The gateway fails closed: without keys it runs only when told to
+	switch hasKeys := c.JWTHS256Secret != "" || c.JWTJWKSPath != ""; {
+	case !hasKeys && !c.AuthDisabled:
+		ps.add("jwt_hs256_secret", "", "required, or jwt_jwks_path, unless auth_disabled is set")
+	case hasKeys && c.AuthDisabled:
+		ps.add("auth_disabled", "", "must not be set with jwt_hs256_secret or jwt_jwks_path")
+	case c.JWTHS256Secret != "" && len(c.JWTHS256Secret) < minHS256SecretLen:
+		ps.add("jwt_hs256_secret", "", "must be at least %d bytes", minHS256SecretLen)
+	}
+	if c.JWTAdminScope == "" || strings.ContainsAny(c.JWTAdminScope, " \t") {
+		ps.add("jwt_admin_scope", "", "%q is not a single scope", c.JWTAdminScope)
+	}
+	// A revoked session is forgotten once its refresh token expires, so its
+	// access tokens must have expired by then
+	if c.AccessTokenTTL <= 0 {
+		ps.add("access_token_ttl", "", "must be positive, got %s", c.AccessTokenTTL)
+	} else if c.AccessTokenTTL >= c.RefreshTokenTTL {
+		ps.add("access_token_ttl", "", "must be shorter than refresh_token_ttl (%s), got %s", c.RefreshTokenTTL, c.AccessTokenTTL)
+	}
+	if c.VerificationTokenTTL <= 0 {
+		ps.add("verification_token_ttl", "", "must be positive, got %s", c.VerificationTokenTTL)
+	}
+
+	// Keep only problems with keys this process uses
+	ps = slices.DeleteFunc(ps, func(p Problem) bool {
+		o, _ := lookupOption(p.Key)
+		return !o.in(c.components)
+	})
+	return ps.err()
+}
+
+// minHS256SecretLen is the HS256 key size RFC 7518 requires: as long as the
+// hash output
+const minHS256SecretLen = 32
+
+// checkKeyPair requires the <prefix>_cert and <prefix>_key keys to be set
+// together
+func checkKeyPair(ps *problems, prefix, cert, key string) {
+	if cert != "" && key == "" {
+		ps.add(prefix+"_key", "", "required when %s_cert is set", prefix)
+	}
+	if cert == "" && key != "" {
+		ps.add(prefix+"_cert", "", "required when %s_key is set", prefix)
+	}
+}
+
+// checkListenAddr requires a [host]:port address such as ":50051"
+func checkListenAddr(ps *problems, key, addr string) {
+	if _, port, err := net.SplitHostPort(addr); err != nil || !validPort(port) {
+		ps.add(key, "", "%q is not a listen address such as :50051", addr)
+	}
+}
+
+// ephemeral reports whether addr asks the OS to pick a free port
+func ephemeral(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && (port == "0" || port == "")
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
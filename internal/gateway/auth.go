@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mr1hm/grpc-demo/internal/auth"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// publicMethods may be called without a bearer token
+var publicMethods = []string{
+	gatewaypb.GatewayService_RegisterUser_FullMethodName,
+	gatewaypb.GatewayService_VerifyEmail_FullMethodName,
+	gatewaypb.GatewayService_ResendVerification_FullMethodName,
+	gatewaypb.GatewayService_Login_FullMethodName,
+	gatewaypb.GatewayService_RefreshToken_FullMethodName,
+	gatewaypb.GatewayService_Logout_FullMethodName,
+}
+
+// newVerifier returns the bearer token verifier configured in cfg, or nil
+// when auth_disabled is set and callers are not authenticated. Without keys
+// and without auth_disabled it fails rather than let everyone in. Tokens of
+// sessions ended by Logout or refresh token reuse are rejected.
+func newVerifier(cfg *config.Config, s *Service) (*auth.Verifier, error) {
+	if cfg.JWTHS256Secret == "" && cfg.JWTJWKSPath == "" {
+		if !cfg.AuthDisabled {
+			return nil, errors.New("no jwt_hs256_secret or jwt_jwks_path configured; set auth_disabled to run without authentication")
+		}
+		s.log.Warn("Authentication is disabled: every caller may act on any account. Never use auth_disabled in production.")
+		return nil, nil
+	}
+	opts := auth.Options{
+		HS256Secret: []byte(cfg.JWTHS256Secret),
+		Issuer:      cfg.JWTIssuer,
+		Audience:    cfg.JWTAudience,
+	}
+	if s.sessions != nil {
+		opts.Revoked = s.sessions.Revoked
+	}
+	if cfg.JWTJWKSPath != "" {
+		keys, err := auth.LoadKeySet(cfg.JWTJWKSPath, s.log)
+		if err != nil {
+			return nil, err
+		}
+		opts.Keys = keys
+	}
+	return auth.NewVerifier(opts)
+}
+
+// authorizeUser lets the caller act on the account userID only if it is
+// their own or they hold the admin scope
+func (s *Service) authorizeUser(ctx context.Context, userID string) error {
+	if s.verifier == nil {
+		return nil
+	}
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	if p.Subject == userID || p.HasScope(s.live.Load().JWTAdminScope) {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, "callers may only access their own account")
+}
+
+// authorizeAdmin lets the caller continue only if they hold the admin scope
+func (s *Service) authorizeAdmin(ctx context.Context) error {
+	if s.verifier == nil {
+		return nil
+	}
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	if scope := s.live.Load().JWTAdminScope; !p.HasScope(scope) {
+		return status.Errorf(codes.PermissionDenied, "requires the %s scope", scope)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mr1hm/grpc-demo/internal/auth"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newAuthGatewayService(t *testing.T, mock *mockUserClient) *Service {
+	t.Helper()
+	cfg := config.New(":0", ":0")
+	cfg.SetOutput(io.Discard)
+	cfg.JWTHS256Secret = testSecret
+	svc := NewServiceWithClient(cfg, mock)
+	t.Cleanup(func() { svc.Close() })
+	return svc
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	mock := &mockUserClient{
+		getUser: func(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+			return &userpb.GetUserResponse{UserId: req.UserId, Name: "Ann", Email: "ann@example.com"}, nil
+		},
+		listUsers: func(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+			return &userpb.ListUsersResponse{}, nil
+		},
+	}
+	svc := newAuthGatewayService(t, mock)
+
+	tests := []struct {
+		name        string
+		principal   *auth.Principal
+		userID      string
+		wantProfile codes.Code
+		wantList    codes.Code
+	}{
+		{name: "own profile", principal: &auth.Principal{Subject: "user-1"}, userID: "user-1", wantList: codes.PermissionDenied},
+		{name: "another profile", principal: &auth.Principal{Subject: "user-1"}, userID: "user-2", wantProfile: codes.PermissionDenied, wantList: codes.PermissionDenied},
+		{name: "admin", principal: &auth.Principal{Subject: "user-1", Scopes: []string{"profile", "admin"}}, userID: "user-2"},
+		{name: "unauthenticated", userID: "user-1", wantProfile: codes.Unauthenticated, wantList: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = auth.NewContext(ctx, tt.principal)
+			}
+			_, err := svc.GetUserProfile(ctx, &gatewaypb.GetUserProfileRequest{UserId: tt.userID})
+			if got := status.Code(err); got != tt.wantProfile {
+				t.Errorf("GetUserProfile: got code %v, want %v (%v)", got, tt.wantProfile, err)
+			}
+			_, err = svc.ListUsers(ctx, &gatewaypb.ListUsersRequest{})
+			if got := status.Code(err); got != tt.wantList {
+				t.Errorf("ListUsers: got code %v, want %v (%v)", got, tt.wantList, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUser_AdminScopeFollowsConfig(t *testing.T) {
+	svc := newAuthGatewayService(t, &mockUserClient{})
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "user-1", Scopes: []string{"support"}})
+
+	if err := svc.authorizeUser(ctx, "user-2"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("support scope before reload: got %v, want PermissionDenied", err)
+	}
+	next := *svc.cfg.Current()
+	next.JWTAdminScope = "support"
+	svc.applyConfig(&next)
+	if err := svc.authorizeUser(ctx, "user-2"); err != nil {
+		t.Errorf("support scope after reload: %v", err)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	mock := &mockUserClient{
+		getUser: func(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+			return &userpb.GetUserResponse{UserId: req.UserId}, nil
+		},
+		createUser: func(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+			return &userpb.CreateUserResponse{UserId: "user-1"}, nil
+		},
+	}
+	svc := newAuthGatewayService(t, mock)
+	conn, err := grpc.NewClient(serve(t, svc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := gatewaypb.NewGatewayServiceClient(conn)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), auth.AuthorizationHeader, "Bearer "+token)
+	}
+
+	if _, err := client.RegisterUser(context.Background(), &gatewaypb.RegisterUserRequest{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Errorf("RegisterUser without a token: %v", err)
+	}
+	if _, err := client.GetUserProfile(context.Background(), &gatewaypb.GetUserProfileRequest{UserId: "user-1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserProfile without a token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(bearer("garbage"), &gatewaypb.GetUserProfileRequest{UserId: "user-1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserProfile with an invalid token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(bearer(token), &gatewaypb.GetUserProfileRequest{UserId: "user-1"}); err != nil {
+		t.Errorf("GetUserProfile of self: %v", err)
+	}
+	if _, err := client.GetUserProfile(bearer(token), &gatewaypb.GetUserProfileRequest{UserId: "user-2"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetUserProfile of another user: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestNewVerifier_FailsClosed(t *testing.T) {
+	cfg := config.New(":0", ":0")
+	cfg.SetOutput(io.Discard)
+	s := &Service{log: cfg.PackageLogger("gateway")}
+
+	if _, err := newVerifier(cfg, s); err == nil {
+		t.Error("newVerifier without keys succeeded, want an error")
+	}
+	cfg.AuthDisabled = true
+	if v, err := newVerifier(cfg, s); v != nil || err != nil {
+		t.Errorf("newVerifier with auth_disabled = %v, %v; want no verifier", v, err)
+	}
+}
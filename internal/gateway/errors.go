@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// ErrorDomain is the ErrorInfo domain of every error returned by the gateway
+const ErrorDomain = "gatewaypb.GatewayService"
+
+// Public error reasons. Clients may branch on these; they never change meaning.
+const (
+	ReasonInvalidArgument       = "INVALID_ARGUMENT"
+	ReasonNotFound              = "NOT_FOUND"
+	ReasonAlreadyExists         = "ALREADY_EXISTS"
+	ReasonDuplicateRegistration = "DUPLICATE_REGISTRATION"
+	ReasonFailedPrecondition    = "FAILED_PRECONDITION"
+	ReasonOutOfRange            = "OUT_OF_RANGE"
+	ReasonAborted               = "ABORTED"
+	ReasonRateLimited           = "RATE_LIMITED"
+	ReasonCancelled             = "CANCELLED"
+	ReasonTimeout               = "TIMEOUT"
+	ReasonUnavailable           = "SERVICE_UNAVAILABLE"
+	ReasonInternal              = "INTERNAL"
+	ReasonInvalidCredentials    = "INVALID_CREDENTIALS"
+	ReasonUserSuspended         = "USER_SUSPENDED"
+	ReasonEmailNotVerified      = "EMAIL_NOT_VERIFIED"
+)
+
+// Values for config.Config.GatewayErrorDetails
+const (
+	// DetailsStrip drops every upstream error detail
+	DetailsStrip = "strip"
+	// DetailsPublic forwards only details meant for the caller, such as
+	// BadRequest field violations and RetryInfo (the default)
+	DetailsPublic = "public"
+	// DetailsAll forwards every upstream detail; for debugging only
+	DetailsAll = "all"
+)
+
+// publicError is what a client sees for a given upstream failure
+type publicError struct {
+	code   codes.Code
+	reason string
+	// message replaces the upstream message; empty keeps it
+	message string
+}
+
+// internalError hides upstream server-side failures behind a generic message
+var internalError = publicError{codes.Internal, ReasonInternal, "internal error"}
+
+// publicErrors maps each upstream code to its public form. Codes describing
+// the caller's request pass through; codes describing a fault between the
+// gateway and the user service become Internal, since the caller cannot act on them.
+var publicErrors = map[codes.Code]publicError{
+	codes.Canceled:           {codes.Canceled, ReasonCancelled, "request cancelled"},
+	codes.Unknown:            internalError,
+	codes.InvalidArgument:    {codes.InvalidArgument, ReasonInvalidArgument, ""},
+	codes.DeadlineExceeded:   {codes.DeadlineExceeded, ReasonTimeout, "request timed out"},
+	codes.NotFound:           {codes.NotFound, ReasonNotFound, ""},
+	codes.AlreadyExists:      {codes.AlreadyExists, ReasonAlreadyExists, ""},
+	codes.PermissionDenied:   internalError,
+	codes.ResourceExhausted:  {codes.ResourceExhausted, ReasonRateLimited, "too many requests"},
+	codes.FailedPrecondition: {codes.FailedPrecondition, ReasonFailedPrecondition, ""},
+	codes.Aborted:            {codes.Aborted, ReasonAborted, "request aborted, please retry"},
+	codes.OutOfRange:         {codes.OutOfRange, ReasonOutOfRange, ""},
+	codes.Unimplemented:      internalError,
+	codes.Internal:           internalError,
+	codes.Unavailable:        {codes.Unavailable, ReasonUnavailable, "service temporarily unavailable"},
+	codes.DataLoss:           internalError,
+	codes.Unauthenticated:    internalError,
+}
+
+// reasonOverrides refines the public error for specific upstream ErrorInfo
+// reasons (see the Reason constants in internal/user)
+var reasonOverrides = map[string]publicError{
+	"EMAIL_ALREADY_REGISTERED": {codes.AlreadyExists, ReasonDuplicateRegistration, "a user with this email address is already registered"},
+	"INVALID_CREDENTIALS":      {codes.Unauthenticated, ReasonInvalidCredentials, "invalid email or password"},
+	"USER_SUSPENDED":           {codes.PermissionDenied, ReasonUserSuspended, "user is suspended"},
+	"EMAIL_NOT_VERIFIED":       {codes.FailedPrecondition, ReasonEmailNotVerified, "verify your email address before logging in"},
+}
+
+// translateError converts an error from the user service into the status
+// returned to gateway clients, logging the original with the request's logger.
+func (s *Service) translateError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st := upstreamStatus(err)
+	pub, ok := publicErrors[st.Code()]
+	if !ok {
+		pub = internalError
+	}
+	var reason string
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			reason = info.Reason
+			if o, ok := reasonOverrides[info.Reason]; ok {
+				pub = o
+			}
+		}
+	}
+
+	// Upstream messages may quote what the caller sent, so only the code and
+	// reason of an expected error are logged
+	if pub.code == codes.Internal {
+		s.logger(ctx).WithError(err).Error("User service error")
+	} else {
+		s.logger(ctx).WithFields(logrus.Fields{"upstream_code": st.Code().String(), "upstream_reason": reason}).Info("User service returned an error")
+	}
+
+	msg := pub.message
+	if msg == "" {
+		msg = st.Message()
+	}
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason: pub.reason,
+		Domain: ErrorDomain,
+	}}
+	details = append(details, forwardedDetails(st, s.live.Load().GatewayErrorDetails)...)
+
+	out := status.New(pub.code, msg)
+	withDetails, derr := out.WithDetails(details...)
+	if derr != nil {
+		return out.Err()
+	}
+	return withDetails.Err()
+}
+
+// upstreamStatus extracts the gRPC status from err, treating context errors
+// and plain errors the way the gRPC client would.
+func upstreamStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.New(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.New(codes.Unknown, err.Error())
+	}
+}
+
+// forwardedDetails selects the upstream details that policy lets through.
+// Upstream ErrorInfo is always replaced by the gateway's own.
+func forwardedDetails(st *status.Status, policy string) []protoadapt.MessageV1 {
+	var out []protoadapt.MessageV1
+	for _, d := range st.Details() {
+		msg, ok := d.(protoadapt.MessageV1)
+		if !ok {
+			continue // undecodable detail
+		}
+		if _, isInfo := d.(*errdetails.ErrorInfo); isInfo {
+			continue
+		}
+
+		switch policy {
+		case DetailsAll:
+			out = append(out, msg)
+		case DetailsStrip:
+		default:
+			if isPublicDetail(d) {
+				out = append(out, msg)
+			}
+		}
+	}
+	return out
+}
+
+// isPublicDetail reports whether an upstream detail is addressed to the caller
+// rather than describing the user service's internals.
+func isPublicDetail(d any) bool {
+	switch d.(type) {
+	case *errdetails.BadRequest,
+		*errdetails.PreconditionFailure,
+		*errdetails.QuotaFailure,
+		*errdetails.RetryInfo,
+		*errdetails.Help,
+		*errdetails.LocalizedMessage:
+		return true
+	default:
+		// ResourceInfo, DebugInfo, RequestInfo and anything unknown
+		return false
+	}
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ServiceName is the name the service's health is reported under
+var ServiceName = gatewaypb.GatewayService_ServiceDesc.ServiceName
+
+// Health returns the health service of servers created by NewServer. It
+// reports the overall status under "" and the GatewayService's under
+// ServiceName; both follow the health of the User service.
+func (s *Service) Health() *health.Server {
+	return s.health
+}
+
+// setServing reports the gateway as serving or not
+func (s *Service) setServing(serving bool) {
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", st)
+	s.health.SetServingStatus(ServiceName, st)
+}
+
+// watchUpstream checks the User service's health every
+// UpstreamHealthInterval until ctx is done, and reports the gateway as
+// serving only while the User service is
+func (s *Service) watchUpstream(ctx context.Context, client healthpb.HealthClient) {
+	serving := false
+	for {
+		interval := s.live.Load().UpstreamHealthInterval
+		up := s.checkUpstream(ctx, client, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		if up != serving {
+			serving = up
+			s.setServing(up)
+			if up {
+				s.log.Info("User service is healthy; serving")
+			} else {
+				s.log.Warn("User service is unhealthy; not serving")
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// checkUpstream reports whether the User service answers a health check for
+// its service within timeout
+func (s *Service) checkUpstream(ctx context.Context, client healthpb.HealthClient, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: userpb.UserService_ServiceDesc.ServiceName})
+	if err != nil {
+		s.log.WithError(err).Debug("User service health check failed")
+		return false
+	}
+	return resp.Status == healthpb.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealth_FollowsUserService(t *testing.T) {
+	cfg := config.New(":0", "127.0.0.1:0")
+	cfg.SetOutput(io.Discard)
+	cfg.AuthDisabled = true
+	cfg.UpstreamHealthInterval = 10 * time.Millisecond
+	userSvc := user.NewServiceWithStore(cfg, user.NewMemoryStore())
+	t.Cleanup(func() { userSvc.Close() })
+	cfg.UserServiceAddr = serve(t, userSvc.NewServer())
+
+	gatewaySvc := NewService(cfg)
+	t.Cleanup(func() { gatewaySvc.Close() })
+	gatewayServer, err := gatewaySvc.Start(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(gatewayServer.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	steps := []struct {
+		name     string
+		upstream healthpb.HealthCheckResponse_ServingStatus
+		want     healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{name: "user service serving", upstream: healthpb.HealthCheckResponse_SERVING, want: healthpb.HealthCheckResponse_SERVING},
+		{name: "user service not serving", upstream: healthpb.HealthCheckResponse_NOT_SERVING, want: healthpb.HealthCheckResponse_NOT_SERVING},
+		{name: "user service recovered", upstream: healthpb.HealthCheckResponse_SERVING, want: healthpb.HealthCheckResponse_SERVING},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			userSvc.Health().SetServingStatus(user.ServiceName, tt.upstream)
+			for _, service := range []string{"", ServiceName} {
+				waitForStatus(t, client, service, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealth_UserServiceUnreachable(t *testing.T) {
+	cfg := config.New(":0", "127.0.0.1:0")
+	cfg.SetOutput(io.Discard)
+	cfg.AuthDisabled = true
+	cfg.UpstreamHealthInterval = 10 * time.Millisecond
+	cfg.UserServiceAddr = "127.0.0.1:1"
+
+	gatewaySvc := NewService(cfg)
+	t.Cleanup(func() { gatewaySvc.Close() })
+	gatewayServer, err := gatewaySvc.Start(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(gatewayServer.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	waitForStatus(t, healthpb.NewHealthClient(conn), ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// waitForStatus fails the test unless service reaches want within a second
+func waitForStatus(t *testing.T, client healthpb.HealthClient, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	var got healthpb.HealthCheckResponse_ServingStatus
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q) failed: %v", service, err)
+		}
+		if got = resp.Status; got == want {
+			return
+		}
+	}
+	t.Errorf("Check(%q) = %s, want %s", service, got, want)
+}
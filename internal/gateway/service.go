@@ -4,115 +4,424 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
 
+	"github.com/mr1hm/grpc-demo/internal/auth"
+	"github.com/mr1hm/grpc-demo/internal/certs"
 	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/logging"
+	"github.com/mr1hm/grpc-demo/internal/metrics"
+	"github.com/mr1hm/grpc-demo/internal/server"
+	"github.com/mr1hm/grpc-demo/internal/session"
+	"github.com/mr1hm/grpc-demo/internal/tracing"
+	"github.com/mr1hm/grpc-demo/internal/validation"
 	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
 	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 // Service implements the GatewayService gRPC server
 type Service struct {
 	cfg *config.Config
+	log *logrus.Entry
 	gatewaypb.UnimplementedGatewayServiceServer
 	userClient userpb.UserServiceClient
 	conn       *grpc.ClientConn
+	health     *health.Server
+	creds      credentials.TransportCredentials
+	verifier   *auth.Verifier
+	signer     *auth.Signer
+	sessions   *session.Store
+	stopWatch  context.CancelFunc
+
+	// live holds the reloadable settings currently in effect
+	live        atomic.Pointer[config.Config]
+	unsubscribe func()
+}
+
+// NewService creates a new Gateway service that connects to the User service at cfg.UserServiceAddr,
+// over TLS when tls_ca is set
+func NewService(cfg *config.Config) *Service {
+	return NewServiceWithAddr(cfg, cfg.UserServiceAddr)
 }
 
-// NewService creates a new Gateway service that connects to the User service
-func NewService(cfg *config.Config, userServiceAddr string) *Service {
-	conn, err := grpc.NewClient(userServiceAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewServiceWithAddr creates a Gateway service that connects to the User
+// service at userAddr instead, such as a listener started in the same process
+func NewServiceWithAddr(cfg *config.Config, userAddr string) *Service {
+	s := newService(cfg, nil)
+	creds := insecure.NewCredentials()
+	if cfg.TLSCA != "" {
+		src, err := certs.NewSource(cfg.GatewayClientTLSCert, cfg.GatewayClientTLSKey, cfg.TLSCA, s.log)
+		if err != nil {
+			cfg.Fatalf("Failed to load Gateway client TLS files: %v", err)
+		}
+		creds = credentials.NewTLS(certs.ClientConfig(src))
+	}
+	conn, err := grpc.NewClient(userAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(tracing.ClientHandler()),
+		grpc.WithChainUnaryInterceptor(metrics.Client.UnaryClientInterceptor(), s.upstreamTimeout, logging.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(metrics.Client.StreamClientInterceptor(), logging.StreamClientInterceptor()),
+	)
 	if err != nil {
 		cfg.Fatalf("Failed to connect to user service: %v", err)
 	}
 
-	return &Service{
-		cfg:        cfg,
-		userClient: userpb.NewUserServiceClient(conn),
-		conn:       conn,
-	}
+	s.userClient = userpb.NewUserServiceClient(conn)
+	s.conn = conn
+
+	// Not serving until the User service is known to be
+	s.setServing(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWatch = cancel
+	go s.watchUpstream(ctx, healthpb.NewHealthClient(conn))
+	return s
 }
 
 // NewServiceWithClient creates a Gateway service with a provided client (for testing)
 func NewServiceWithClient(cfg *config.Config, userClient userpb.UserServiceClient) *Service {
-	return &Service{
+	return newService(cfg, userClient)
+}
+
+func newService(cfg *config.Config, userClient userpb.UserServiceClient) *Service {
+	s := &Service{
 		cfg:        cfg,
+		log:        cfg.PackageLogger("gateway"),
 		userClient: userClient,
+		health:     health.NewServer(),
+		stopWatch:  func() {},
+	}
+	s.setServing(true)
+	if cfg.GatewayTLSCert != "" {
+		src, err := certs.NewSource(cfg.GatewayTLSCert, cfg.GatewayTLSKey, "", s.log)
+		if err != nil {
+			cfg.Fatalf("Failed to load Gateway TLS files: %v", err)
+		}
+		s.creds = credentials.NewTLS(certs.ServerConfig(src))
+	}
+	if cfg.JWTHS256Secret != "" {
+		s.signer = auth.NewSigner([]byte(cfg.JWTHS256Secret), cfg.JWTIssuer, cfg.JWTAudience)
+		// Sessions are in memory: a replica would not know this one's refresh tokens
+		s.sessions = session.NewStore()
+	}
+	verifier, err := newVerifier(cfg, s)
+	if err != nil {
+		cfg.Fatalf("Failed to set up bearer token verification: %v", err)
+	}
+	s.verifier = verifier
+	s.live.Store(cfg.Current())
+	s.unsubscribe = cfg.Subscribe(s.applyConfig)
+	return s
+}
+
+// applyConfig switches to a reloaded configuration
+func (s *Service) applyConfig(next *config.Config) {
+	s.live.Store(next)
+	s.log.Debug("Applied reloaded configuration")
+}
+
+// logger returns the logger of the request in ctx
+func (s *Service) logger(ctx context.Context) *logrus.Entry {
+	return logging.FromContext(ctx, s.log)
+}
+
+// upstreamTimeout bounds each call to the User service by the current
+// UpstreamTimeout. An earlier deadline from the caller still wins.
+func (s *Service) upstreamTimeout(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if d := s.live.Load().UpstreamTimeout; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
+	return invoker(ctx, method, req, reply, cc, opts...)
 }
 
-// Close closes the client connection
+// Close stops following config reloads and checking the User service's
+// health, and closes the client connection
 func (s *Service) Close() error {
+	s.unsubscribe()
+	s.stopWatch()
 	if s.conn != nil {
 		return s.conn.Close()
 	}
 	return nil
 }
 
-// Start creates a listener, registers the service, and starts serving in a goroutine.
-// Returns the server for graceful shutdown.
-func (s *Service) Start() *grpc.Server {
-	lis, err := net.Listen("tcp", s.cfg.GatewayServicePort)
-	if err != nil {
-		s.cfg.Fatalf("Gateway service failed to listen: %v", err)
+// NewServer returns a gRPC server with the service and its health service
+// registered and its tracing and logging instrumentation installed, for
+// callers that serve it themselves. It serves TLS when gateway_tls_cert is
+// set, and requires bearer tokens when JWT keys are configured.
+func (s *Service) NewServer() *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{metrics.Server.UnaryServerInterceptor(), logging.UnaryServerInterceptor(s.log)}
+	stream := []grpc.StreamServerInterceptor{metrics.Server.StreamServerInterceptor(), logging.StreamServerInterceptor(s.log)}
+	if s.verifier != nil {
+		unary = append(unary, s.verifier.UnaryServerInterceptor(ServiceName, publicMethods...))
+		stream = append(stream, s.verifier.StreamServerInterceptor(ServiceName, publicMethods...))
 	}
-
-	server := grpc.NewServer()
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(tracing.ServerHandler()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if s.creds != nil {
+		opts = append(opts, grpc.Creds(s.creds))
+	}
+	server := grpc.NewServer(opts...)
 	gatewaypb.RegisterGatewayServiceServer(server, s)
+	healthpb.RegisterHealthServer(server, s.health)
 	reflection.Register(server)
+	metrics.Server.InitializeMetrics(server)
+	return server
+}
 
-	go func() {
-		s.cfg.Infof("[Gateway Service] Starting on %s", s.cfg.GatewayServicePort)
-		if err := server.Serve(lis); err != nil {
-			s.cfg.Fatalf("Gateway service error: %v", err)
+// Start serves the service in a goroutine on lis, or on a new listener on
+// GatewayServicePort when lis is nil, until ctx is done or the returned server is
+// stopped. Wait on the server to learn why it stopped serving.
+func (s *Service) Start(ctx context.Context, lis net.Listener) (*server.Server, error) {
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", s.cfg.GatewayServicePort); err != nil {
+			return nil, fmt.Errorf("listen on %s: %w", s.cfg.GatewayServicePort, err)
 		}
-	}()
-
-	return server
+	}
+	s.log.Infof("Starting on %s", lis.Addr())
+	return server.Serve(ctx, s.NewServer(), lis), nil
 }
 
 // GetUserProfile gets a user profile by calling the internal User service
 func (s *Service) GetUserProfile(ctx context.Context, req *gatewaypb.GetUserProfileRequest) (*gatewaypb.GetUserProfileResponse, error) {
-	s.cfg.Infof("[Gateway] GetUserProfile called for user: %s", req.UserId)
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Getting user profile")
+	if err := s.authorizeUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
 
 	// Call internal User service
 	userResp, err := s.userClient.GetUser(ctx, &userpb.GetUserRequest{
 		UserId: req.UserId,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user from user service: %w", err)
+		return nil, s.translateError(ctx, err)
 	}
 
-	s.cfg.Infof("[Gateway] Received user data from User service: %+v", userResp)
+	s.logger(ctx).WithField("response", logging.Proto(userResp)).Debug("Received user from User service")
 
 	// Gateway adds additional data/processing
 	return &gatewaypb.GetUserProfileResponse{
 		UserId: userResp.UserId,
 		Name:   userResp.Name,
 		Email:  userResp.Email,
-		Status: "active", // Gateway enriches the response
+		Status: statusName(userResp.Status),
 	}, nil
 }
 
 // RegisterUser registers a new user via the internal User service
 func (s *Service) RegisterUser(ctx context.Context, req *gatewaypb.RegisterUserRequest) (*gatewaypb.RegisterUserResponse, error) {
-	s.cfg.Infof("[Gateway] RegisterUser called: name=%s, email=%s", req.Name, req.Email)
+	s.logger(ctx).WithField("request", logging.Proto(req)).Debug("Registering user")
+
+	// Reject bad input here rather than spending a round trip on it
+	var v validation.Violations
+	name := validation.Name(&v, "name", req.Name)
+	email := validation.Email(&v, "email", req.Email)
+	if req.Password != "" {
+		validation.Password(&v, "password", req.Password)
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
 
 	// Call internal User service
 	userResp, err := s.userClient.CreateUser(ctx, &userpb.CreateUserRequest{
-		Name:  req.Name,
-		Email: req.Email,
+		Name:     name,
+		Email:    email,
+		Password: req.Password,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user via user service: %w", err)
+		return nil, s.translateError(ctx, err)
 	}
 
-	s.cfg.Infof("[Gateway] User created via User service: %+v", userResp)
+	s.logger(ctx).WithField("user_id", userResp.UserId).Info("Registered user")
 
 	return &gatewaypb.RegisterUserResponse{
 		UserId:  userResp.UserId,
-		Message: fmt.Sprintf("User %s registered successfully", userResp.Name),
+		Message: fmt.Sprintf("User %s registered successfully; check %s for a verification token", userResp.Name, userResp.Email),
+		Status:  statusName(userResp.Status),
+	}, nil
+}
+
+// UpdateUser updates a user's name and/or email via the internal User service
+func (s *Service) UpdateUser(ctx context.Context, req *gatewaypb.UpdateUserRequest) (*gatewaypb.UpdateUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Updating user")
+	if err := s.authorizeUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	userResp, err := s.userClient.UpdateUser(ctx, &userpb.UpdateUserRequest{
+		UserId:     req.UserId,
+		Name:       req.Name,
+		Email:      req.Email,
+		UpdateMask: req.UpdateMask,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.UpdateUserResponse{
+		UserId: userResp.UserId,
+		Name:   userResp.Name,
+		Email:  userResp.Email,
+		Status: statusName(userResp.Status),
+	}, nil
+}
+
+// DeleteUser soft-deletes a user via the internal User service
+func (s *Service) DeleteUser(ctx context.Context, req *gatewaypb.DeleteUserRequest) (*gatewaypb.DeleteUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Deleting user")
+	if err := s.authorizeUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	userResp, err := s.userClient.DeleteUser(ctx, &userpb.DeleteUserRequest{
+		UserId: req.UserId,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.DeleteUserResponse{
+		UserId:    userResp.UserId,
+		DeletedAt: userResp.DeletedAt,
+	}, nil
+}
+
+// ListUsers lists a page of user profiles via the internal User service
+func (s *Service) ListUsers(ctx context.Context, req *gatewaypb.ListUsersRequest) (*gatewaypb.ListUsersResponse, error) {
+	s.logger(ctx).WithFields(logrus.Fields{"page_size": req.PageSize, "order_by": req.OrderBy}).Debug("Listing users")
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	userStatus := userpb.UserStatus_USER_STATUS_UNSPECIFIED
+	if req.Status != "" {
+		v, ok := userpb.UserStatus_value[statusPrefix+strings.ToUpper(req.Status)]
+		if !ok || v == int32(userpb.UserStatus_USER_STATUS_UNSPECIFIED) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", req.Status)
+		}
+		userStatus = userpb.UserStatus(v)
+	}
+
+	userResp, err := s.userClient.ListUsers(ctx, &userpb.ListUsersRequest{
+		ShowDeleted: req.ShowDeleted,
+		PageSize:    req.PageSize,
+		PageToken:   req.PageToken,
+		NamePrefix:  req.NamePrefix,
+		EmailPrefix: req.EmailPrefix,
+		Status:      userStatus,
+		OrderBy:     req.OrderBy,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	resp := &gatewaypb.ListUsersResponse{
+		NextPageToken: userResp.NextPageToken,
+	}
+	for _, u := range userResp.Users {
+		resp.Users = append(resp.Users, &gatewaypb.UserProfile{
+			UserId: u.UserId,
+			Name:   u.Name,
+			Email:  u.Email,
+			Status: statusName(u.Status),
+		})
+	}
+	return resp, nil
+}
+
+// SuspendUser suspends a user via the internal User service
+func (s *Service) SuspendUser(ctx context.Context, req *gatewaypb.SuspendUserRequest) (*gatewaypb.SuspendUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Suspending user")
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	userResp, err := s.userClient.SuspendUser(ctx, &userpb.SuspendUserRequest{
+		UserId: req.UserId,
+		Reason: req.Reason,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.SuspendUserResponse{
+		UserId: userResp.UserId,
+		Status: statusName(userResp.Status),
+	}, nil
+}
+
+// ReactivateUser reactivates a suspended user via the internal User service
+func (s *Service) ReactivateUser(ctx context.Context, req *gatewaypb.ReactivateUserRequest) (*gatewaypb.ReactivateUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Reactivating user")
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	userResp, err := s.userClient.ReactivateUser(ctx, &userpb.ReactivateUserRequest{
+		UserId: req.UserId,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.ReactivateUserResponse{
+		UserId: userResp.UserId,
+		Status: statusName(userResp.Status),
+	}, nil
+}
+
+// VerifyEmail redeems an email verification token via the internal User service
+func (s *Service) VerifyEmail(ctx context.Context, req *gatewaypb.VerifyEmailRequest) (*gatewaypb.VerifyEmailResponse, error) {
+	s.logger(ctx).Debug("Verifying email")
+
+	userResp, err := s.userClient.VerifyEmail(ctx, &userpb.VerifyEmailRequest{
+		Token: req.Token,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.VerifyEmailResponse{
+		UserId: userResp.UserId,
+		Status: statusName(userResp.Status),
 	}, nil
 }
+
+// ResendVerification emails a new verification token via the internal User service
+func (s *Service) ResendVerification(ctx context.Context, req *gatewaypb.ResendVerificationRequest) (*gatewaypb.ResendVerificationResponse, error) {
+	s.logger(ctx).Debug("Resending verification email")
+
+	if _, err := s.userClient.ResendVerification(ctx, &userpb.ResendVerificationRequest{
+		Email: req.Email,
+	}); err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	return &gatewaypb.ResendVerificationResponse{}, nil
+}
+
+const statusPrefix = "USER_STATUS_"
+
+// statusName returns the public lowercase name of a user status, such as "pending_verification"
+func statusName(st userpb.UserStatus) string {
+	if st == userpb.UserStatus_USER_STATUS_UNSPECIFIED {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(st.String(), statusPrefix))
+}
@@ -1,22 +1,45 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/user"
 	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
 	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // mockUserClient implements userpb.UserServiceClient for testing
 type mockUserClient struct {
 	getUser    func(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error)
 	createUser func(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error)
+	updateUser func(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error)
+	deleteUser func(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error)
+	listUsers  func(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error)
+
+	suspendUser    func(ctx context.Context, req *userpb.SuspendUserRequest) (*userpb.SuspendUserResponse, error)
+	reactivateUser func(ctx context.Context, req *userpb.ReactivateUserRequest) (*userpb.ReactivateUserResponse, error)
+	verifyEmail    func(ctx context.Context, req *userpb.VerifyEmailRequest) (*userpb.VerifyEmailResponse, error)
+
+	resendVerification func(ctx context.Context, req *userpb.ResendVerificationRequest) (*userpb.ResendVerificationResponse, error)
+
+	authenticateUser func(ctx context.Context, req *userpb.AuthenticateUserRequest) (*userpb.AuthenticateUserResponse, error)
 }
 
 func (m *mockUserClient) GetUser(ctx context.Context, req *userpb.GetUserRequest, opts ...grpc.CallOption) (*userpb.GetUserResponse, error) {
@@ -27,8 +50,41 @@ func (m *mockUserClient) CreateUser(ctx context.Context, req *userpb.CreateUserR
 	return m.createUser(ctx, req)
 }
 
+func (m *mockUserClient) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest, opts ...grpc.CallOption) (*userpb.UpdateUserResponse, error) {
+	return m.updateUser(ctx, req)
+}
+
+func (m *mockUserClient) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest, opts ...grpc.CallOption) (*userpb.DeleteUserResponse, error) {
+	return m.deleteUser(ctx, req)
+}
+
+func (m *mockUserClient) ListUsers(ctx context.Context, req *userpb.ListUsersRequest, opts ...grpc.CallOption) (*userpb.ListUsersResponse, error) {
+	return m.listUsers(ctx, req)
+}
+
+func (m *mockUserClient) SuspendUser(ctx context.Context, req *userpb.SuspendUserRequest, opts ...grpc.CallOption) (*userpb.SuspendUserResponse, error) {
+	return m.suspendUser(ctx, req)
+}
+
+func (m *mockUserClient) ReactivateUser(ctx context.Context, req *userpb.ReactivateUserRequest, opts ...grpc.CallOption) (*userpb.ReactivateUserResponse, error) {
+	return m.reactivateUser(ctx, req)
+}
+
+func (m *mockUserClient) VerifyEmail(ctx context.Context, req *userpb.VerifyEmailRequest, opts ...grpc.CallOption) (*userpb.VerifyEmailResponse, error) {
+	return m.verifyEmail(ctx, req)
+}
+
+func (m *mockUserClient) ResendVerification(ctx context.Context, req *userpb.ResendVerificationRequest, opts ...grpc.CallOption) (*userpb.ResendVerificationResponse, error) {
+	return m.resendVerification(ctx, req)
+}
+
+func (m *mockUserClient) AuthenticateUser(ctx context.Context, req *userpb.AuthenticateUserRequest, opts ...grpc.CallOption) (*userpb.AuthenticateUserResponse, error) {
+	return m.authenticateUser(ctx, req)
+}
+
 func newTestGatewayService(mock *mockUserClient) *Service {
 	cfg := config.New(":50051", ":50052")
+	cfg.AuthDisabled = true
 	return NewServiceWithClient(cfg, mock)
 }
 
@@ -42,16 +98,29 @@ func TestGetUserProfile(t *testing.T) {
 		wantErr    bool
 	}{
 		{
-			name:   "success - adds active status",
+			name:   "success - active status",
 			userID: "user-1",
 			mockResp: &userpb.GetUserResponse{
 				UserId: "user-1",
 				Name:   "John",
 				Email:  "john@example.com",
+				Status: userpb.UserStatus_USER_STATUS_ACTIVE,
 			},
 			wantStatus: "active",
 			wantErr:    false,
 		},
+		{
+			name:   "success - pending verification status",
+			userID: "user-2",
+			mockResp: &userpb.GetUserResponse{
+				UserId: "user-2",
+				Name:   "Jane",
+				Email:  "jane@example.com",
+				Status: userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
+			},
+			wantStatus: "pending_verification",
+			wantErr:    false,
+		},
 		{
 			name:    "user service returns not found",
 			userID:  "nonexistent",
@@ -111,11 +180,12 @@ func TestGetUserProfile(t *testing.T) {
 
 func TestRegisterUser(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    *gatewaypb.RegisterUserRequest
-		mockResp *userpb.CreateUserResponse
-		mockErr  error
-		wantErr  bool
+		name        string
+		input       *gatewaypb.RegisterUserRequest
+		mockResp    *userpb.CreateUserResponse
+		mockErr     error
+		wantErr     bool
+		wantInvalid bool
 	}{
 		{
 			name: "success",
@@ -127,6 +197,7 @@ func TestRegisterUser(t *testing.T) {
 				UserId: "user-1",
 				Name:   "Alice",
 				Email:  "alice@example.com",
+				Status: userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
 			},
 			wantErr: false,
 		},
@@ -139,12 +210,24 @@ func TestRegisterUser(t *testing.T) {
 			mockErr: errors.New("database error"),
 			wantErr: true,
 		},
+		{
+			name: "invalid input is rejected before calling user service",
+			input: &gatewaypb.RegisterUserRequest{
+				Name:  "",
+				Email: "x",
+			},
+			wantErr:     true,
+			wantInvalid: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mock := &mockUserClient{
 				createUser: func(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+					if tt.wantInvalid {
+						t.Error("CreateUser should not be called for invalid input")
+					}
 					if req.Name != tt.input.Name {
 						t.Errorf("CreateUser called with wrong name: got %q, want %q", req.Name, tt.input.Name)
 					}
@@ -162,6 +245,9 @@ func TestRegisterUser(t *testing.T) {
 				if err == nil {
 					t.Fatal("expected error, got nil")
 				}
+				if tt.wantInvalid && status.Code(err) != codes.InvalidArgument {
+					t.Errorf("expected InvalidArgument, got %v", err)
+				}
 				return
 			}
 
@@ -172,9 +258,480 @@ func TestRegisterUser(t *testing.T) {
 			if got.UserId != tt.mockResp.UserId {
 				t.Errorf("UserId = %q, want %q", got.UserId, tt.mockResp.UserId)
 			}
+			if got.Status != "pending_verification" {
+				t.Errorf("Status = %q, want pending_verification", got.Status)
+			}
 			if got.Message == "" {
 				t.Error("Message should not be empty")
 			}
 		})
 	}
 }
+
+func TestUpdateUser(t *testing.T) {
+	mask := &fieldmaskpb.FieldMask{Paths: []string{"email"}}
+	mock := &mockUserClient{
+		updateUser: func(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+			if req.UserId != "user-1" || req.Email != "new@example.com" {
+				t.Errorf("UpdateUser called with %+v", req)
+			}
+			if len(req.UpdateMask.GetPaths()) != 1 || req.UpdateMask.Paths[0] != "email" {
+				t.Errorf("update mask not forwarded: %v", req.UpdateMask)
+			}
+			return &userpb.UpdateUserResponse{UserId: req.UserId, Name: "John", Email: req.Email}, nil
+		},
+	}
+
+	svc := newTestGatewayService(mock)
+	got, err := svc.UpdateUser(context.Background(), &gatewaypb.UpdateUserRequest{
+		UserId:     "user-1",
+		Email:      "new@example.com",
+		UpdateMask: mask,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Email != "new@example.com" || got.Name != "John" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	deletedAt := timestamppb.Now()
+	mock := &mockUserClient{
+		deleteUser: func(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+			if req.UserId == "missing" {
+				return nil, status.Error(codes.NotFound, "user not found")
+			}
+			return &userpb.DeleteUserResponse{UserId: req.UserId, DeletedAt: deletedAt}, nil
+		},
+	}
+
+	svc := newTestGatewayService(mock)
+	got, err := svc.DeleteUser(context.Background(), &gatewaypb.DeleteUserRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.UserId != "user-1" || !got.DeletedAt.AsTime().Equal(deletedAt.AsTime()) {
+		t.Errorf("got %+v", got)
+	}
+
+	if _, err := svc.DeleteUser(context.Background(), &gatewaypb.DeleteUserRequest{UserId: "missing"}); err == nil {
+		t.Error("expected error for missing user, got nil")
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	mock := &mockUserClient{
+		listUsers: func(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+			if !req.ShowDeleted || req.PageSize != 2 || req.PageToken != "tok" || req.OrderBy != "name" ||
+				req.Status != userpb.UserStatus_USER_STATUS_UNSPECIFIED {
+				t.Errorf("request not forwarded: %+v", req)
+			}
+			return &userpb.ListUsersResponse{
+				Users: []*userpb.User{
+					{UserId: "user-1", Name: "John", Status: userpb.UserStatus_USER_STATUS_ACTIVE},
+					{UserId: "user-2", Name: "Jane", Status: userpb.UserStatus_USER_STATUS_DELETED, DeletedAt: timestamppb.Now()},
+				},
+				NextPageToken: "next",
+			}, nil
+		},
+	}
+
+	svc := newTestGatewayService(mock)
+	got, err := svc.ListUsers(context.Background(), &gatewaypb.ListUsersRequest{
+		ShowDeleted: true,
+		PageSize:    2,
+		PageToken:   "tok",
+		OrderBy:     "name",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Users) != 2 {
+		t.Fatalf("got %d users, want 2", len(got.Users))
+	}
+	if got.Users[0].Status != "active" || got.Users[1].Status != "deleted" {
+		t.Errorf("statuses = %q, %q; want active, deleted", got.Users[0].Status, got.Users[1].Status)
+	}
+	if got.NextPageToken != "next" {
+		t.Errorf("NextPageToken = %q, want %q", got.NextPageToken, "next")
+	}
+
+	for _, bad := range []string{"sleepy", "unspecified"} {
+		if _, err := svc.ListUsers(context.Background(), &gatewaypb.ListUsersRequest{Status: bad}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("status %q: expected InvalidArgument, got %v", bad, err)
+		}
+	}
+}
+
+func TestListUsers_StatusFilter(t *testing.T) {
+	tests := map[string]userpb.UserStatus{
+		"pending_verification": userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
+		"active":               userpb.UserStatus_USER_STATUS_ACTIVE,
+		"suspended":            userpb.UserStatus_USER_STATUS_SUSPENDED,
+		"deleted":              userpb.UserStatus_USER_STATUS_DELETED,
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := &mockUserClient{
+				listUsers: func(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+					if req.Status != want {
+						t.Errorf("Status = %v, want %v", req.Status, want)
+					}
+					return &userpb.ListUsersResponse{}, nil
+				},
+			}
+			svc := newTestGatewayService(mock)
+			if _, err := svc.ListUsers(context.Background(), &gatewaypb.ListUsersRequest{Status: name}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSuspendAndReactivateUser(t *testing.T) {
+	mock := &mockUserClient{
+		suspendUser: func(ctx context.Context, req *userpb.SuspendUserRequest) (*userpb.SuspendUserResponse, error) {
+			if req.Reason != "spam" {
+				t.Errorf("Reason = %q, want %q", req.Reason, "spam")
+			}
+			if req.UserId == "user-2" {
+				return nil, status.Error(codes.FailedPrecondition, "cannot change status from deleted to suspended")
+			}
+			return &userpb.SuspendUserResponse{UserId: req.UserId, Status: userpb.UserStatus_USER_STATUS_SUSPENDED}, nil
+		},
+		reactivateUser: func(ctx context.Context, req *userpb.ReactivateUserRequest) (*userpb.ReactivateUserResponse, error) {
+			return &userpb.ReactivateUserResponse{UserId: req.UserId, Status: userpb.UserStatus_USER_STATUS_ACTIVE}, nil
+		},
+	}
+	svc := newTestGatewayService(mock)
+	ctx := context.Background()
+
+	suspended, err := svc.SuspendUser(ctx, &gatewaypb.SuspendUserRequest{UserId: "user-1", Reason: "spam"})
+	if err != nil {
+		t.Fatalf("SuspendUser failed: %v", err)
+	}
+	if suspended.UserId != "user-1" || suspended.Status != "suspended" {
+		t.Errorf("SuspendUser = %+v, want user-1 suspended", suspended)
+	}
+
+	active, err := svc.ReactivateUser(ctx, &gatewaypb.ReactivateUserRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("ReactivateUser failed: %v", err)
+	}
+	if active.UserId != "user-1" || active.Status != "active" {
+		t.Errorf("ReactivateUser = %+v, want user-1 active", active)
+	}
+
+	_, err = svc.SuspendUser(ctx, &gatewaypb.SuspendUserRequest{UserId: "user-2", Reason: "spam"})
+	if st, _ := status.FromError(err); st.Code() != codes.FailedPrecondition || errorReason(st) != ReasonFailedPrecondition {
+		t.Errorf("illegal transition: expected FailedPrecondition/%s, got %v", ReasonFailedPrecondition, err)
+	}
+}
+
+func TestRegisterUser_Duplicate(t *testing.T) {
+	mock := &mockUserClient{
+		createUser: func(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+			st, _ := status.New(codes.AlreadyExists, "email taken").WithDetails(
+				&errdetails.ErrorInfo{Reason: "EMAIL_ALREADY_REGISTERED", Domain: "userpb.UserService"},
+				&errdetails.ResourceInfo{ResourceType: "user", ResourceName: "user-1"},
+			)
+			return nil, st.Err()
+		},
+	}
+
+	svc := newTestGatewayService(mock)
+	_, err := svc.RegisterUser(context.Background(), &gatewaypb.RegisterUserRequest{
+		Name:  "Alice",
+		Email: "alice@example.com",
+	})
+
+	st, _ := status.FromError(err)
+	if st.Code() != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if got := errorReason(st); got != ReasonDuplicateRegistration {
+		t.Errorf("reason = %q, want %q", got, ReasonDuplicateRegistration)
+	}
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.ResourceInfo); ok {
+			t.Error("existing user's ID must not be exposed to clients")
+		}
+	}
+}
+
+// TestRegisterUser_DuplicateLogsNoEmail runs a conflicting registration through
+// both services' interceptors and checks no log line names the address
+func TestRegisterUser_DuplicateLogsNoEmail(t *testing.T) {
+	const email = "alice.secret@example.com"
+	var logs bytes.Buffer
+	cfg := config.New("127.0.0.1:0", "127.0.0.1:0")
+	cfg.SetOutput(&logs)
+	cfg.SetLevel(logrus.DebugLevel)
+	cfg.AuthDisabled = true
+
+	userSvc := user.NewServiceWithStore(cfg, user.NewMemoryStore())
+	t.Cleanup(func() { userSvc.Close() })
+	userConn, err := grpc.NewClient(serve(t, userSvc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { userConn.Close() })
+	svc := NewServiceWithClient(cfg, userpb.NewUserServiceClient(userConn))
+	t.Cleanup(func() { svc.Close() })
+	conn, err := grpc.NewClient(serve(t, svc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := gatewaypb.NewGatewayServiceClient(conn)
+
+	req := &gatewaypb.RegisterUserRequest{Name: "Alice", Email: email}
+	if _, err := client.RegisterUser(context.Background(), req); err != nil {
+		t.Fatalf("RegisterUser failed: %v", err)
+	}
+	logs.Reset()
+	_, err = client.RegisterUser(context.Background(), req)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+
+	if !strings.Contains(logs.String(), "EMAIL_ALREADY_REGISTERED") {
+		t.Errorf("conflict not logged:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "alice.secret") {
+		t.Errorf("log contains the email address:\n%s", logs.String())
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	const upstreamMsg = "user user-7 not found in shard 3"
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantReason  string
+		keepMessage bool
+	}{
+		{name: "Canceled", err: status.Error(codes.Canceled, upstreamMsg), wantCode: codes.Canceled, wantReason: ReasonCancelled},
+		{name: "Unknown", err: status.Error(codes.Unknown, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "InvalidArgument", err: status.Error(codes.InvalidArgument, upstreamMsg), wantCode: codes.InvalidArgument, wantReason: ReasonInvalidArgument, keepMessage: true},
+		{name: "DeadlineExceeded", err: status.Error(codes.DeadlineExceeded, upstreamMsg), wantCode: codes.DeadlineExceeded, wantReason: ReasonTimeout},
+		{name: "NotFound", err: status.Error(codes.NotFound, upstreamMsg), wantCode: codes.NotFound, wantReason: ReasonNotFound, keepMessage: true},
+		{name: "AlreadyExists", err: status.Error(codes.AlreadyExists, upstreamMsg), wantCode: codes.AlreadyExists, wantReason: ReasonAlreadyExists, keepMessage: true},
+		{name: "PermissionDenied", err: status.Error(codes.PermissionDenied, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "ResourceExhausted", err: status.Error(codes.ResourceExhausted, upstreamMsg), wantCode: codes.ResourceExhausted, wantReason: ReasonRateLimited},
+		{name: "FailedPrecondition", err: status.Error(codes.FailedPrecondition, upstreamMsg), wantCode: codes.FailedPrecondition, wantReason: ReasonFailedPrecondition, keepMessage: true},
+		{name: "Aborted", err: status.Error(codes.Aborted, upstreamMsg), wantCode: codes.Aborted, wantReason: ReasonAborted},
+		{name: "OutOfRange", err: status.Error(codes.OutOfRange, upstreamMsg), wantCode: codes.OutOfRange, wantReason: ReasonOutOfRange, keepMessage: true},
+		{name: "Unimplemented", err: status.Error(codes.Unimplemented, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "Internal", err: status.Error(codes.Internal, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "Unavailable", err: status.Error(codes.Unavailable, upstreamMsg), wantCode: codes.Unavailable, wantReason: ReasonUnavailable},
+		{name: "DataLoss", err: status.Error(codes.DataLoss, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "Unauthenticated", err: status.Error(codes.Unauthenticated, upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "plain error", err: errors.New(upstreamMsg), wantCode: codes.Internal, wantReason: ReasonInternal},
+		{name: "context canceled", err: context.Canceled, wantCode: codes.Canceled, wantReason: ReasonCancelled},
+		{name: "context deadline", err: fmt.Errorf("dial: %w", context.DeadlineExceeded), wantCode: codes.DeadlineExceeded, wantReason: ReasonTimeout},
+	}
+
+	svc := newTestGatewayService(&mockUserClient{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(svc.translateError(context.Background(), tt.err))
+			if !ok {
+				t.Fatal("expected a gRPC status")
+			}
+			if st.Code() != tt.wantCode {
+				t.Errorf("code = %v, want %v", st.Code(), tt.wantCode)
+			}
+			if got := errorReason(st); got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+			if (st.Message() == upstreamMsg) != tt.keepMessage {
+				t.Errorf("message = %q, keepMessage = %v", st.Message(), tt.keepMessage)
+			}
+		})
+	}
+
+	// Every code the user service can return must have an explicit mapping
+	for c := codes.Canceled; c <= codes.Unauthenticated; c++ {
+		if _, ok := publicErrors[c]; !ok {
+			t.Errorf("no public mapping for %v", c)
+		}
+	}
+}
+
+func TestTranslateError_DetailPolicy(t *testing.T) {
+	st, _ := status.New(codes.InvalidArgument, "bad").WithDetails(
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "email"}}},
+		&errdetails.ResourceInfo{ResourceType: "user", ResourceName: "user-1"},
+		&errdetails.DebugInfo{Detail: "stack trace"},
+		&errdetails.ErrorInfo{Reason: "UPSTREAM_REASON", Domain: "userpb.UserService"},
+	)
+	upstream := st.Err()
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{policy: DetailsStrip, want: []string{"ErrorInfo"}},
+		{policy: DetailsPublic, want: []string{"ErrorInfo", "BadRequest"}},
+		{policy: "", want: []string{"ErrorInfo", "BadRequest"}},
+		{policy: DetailsAll, want: []string{"ErrorInfo", "BadRequest", "ResourceInfo", "DebugInfo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy="+tt.policy, func(t *testing.T) {
+			svc := newTestGatewayService(&mockUserClient{})
+			svc.cfg.GatewayErrorDetails = tt.policy
+
+			got := status.Convert(svc.translateError(context.Background(), upstream))
+			var kinds []string
+			for _, d := range got.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					if d.Reason != ReasonInvalidArgument || d.Domain != ErrorDomain {
+						t.Errorf("ErrorInfo = %v, want gateway reason", d)
+					}
+					kinds = append(kinds, "ErrorInfo")
+				case *errdetails.BadRequest:
+					kinds = append(kinds, "BadRequest")
+				case *errdetails.ResourceInfo:
+					kinds = append(kinds, "ResourceInfo")
+				case *errdetails.DebugInfo:
+					kinds = append(kinds, "DebugInfo")
+				}
+			}
+			if !slices.Equal(kinds, tt.want) {
+				t.Errorf("details = %v, want %v", kinds, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserProfile_PreservesNotFound(t *testing.T) {
+	mock := &mockUserClient{
+		getUser: func(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+			return nil, status.Error(codes.NotFound, "user user-9 not found")
+		},
+	}
+
+	svc := newTestGatewayService(mock)
+	_, err := svc.GetUserProfile(context.Background(), &gatewaypb.GetUserProfileRequest{UserId: "user-9"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+// errorReason returns the reason of the first ErrorInfo detail in st
+func errorReason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mock := &mockUserClient{
+		verifyEmail: func(ctx context.Context, req *userpb.VerifyEmailRequest) (*userpb.VerifyEmailResponse, error) {
+			if req.Token != "user-1.secret" {
+				return nil, status.Error(codes.InvalidArgument, "verification token is invalid or has already been used")
+			}
+			return &userpb.VerifyEmailResponse{UserId: "user-1", Status: userpb.UserStatus_USER_STATUS_ACTIVE}, nil
+		},
+	}
+	svc := newTestGatewayService(mock)
+
+	got, err := svc.VerifyEmail(context.Background(), &gatewaypb.VerifyEmailRequest{Token: "user-1.secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.UserId != "user-1" || got.Status != "active" {
+		t.Errorf("VerifyEmail = %+v, want user-1 active", got)
+	}
+
+	_, err = svc.VerifyEmail(context.Background(), &gatewaypb.VerifyEmailRequest{Token: "user-1.guess"})
+	if st, _ := status.FromError(err); st.Code() != codes.InvalidArgument || errorReason(st) != ReasonInvalidArgument {
+		t.Errorf("bad token: expected InvalidArgument/%s, got %v", ReasonInvalidArgument, err)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	var sentTo string
+	mock := &mockUserClient{
+		resendVerification: func(ctx context.Context, req *userpb.ResendVerificationRequest) (*userpb.ResendVerificationResponse, error) {
+			if req.Email == "down@example.com" {
+				return nil, status.Error(codes.Unavailable, "failed to send verification email")
+			}
+			sentTo = req.Email
+			return &userpb.ResendVerificationResponse{}, nil
+		},
+	}
+	svc := newTestGatewayService(mock)
+
+	if _, err := svc.ResendVerification(context.Background(), &gatewaypb.ResendVerificationRequest{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sentTo != "alice@example.com" {
+		t.Errorf("ResendVerification sent to %q, want alice@example.com", sentTo)
+	}
+
+	_, err := svc.ResendVerification(context.Background(), &gatewaypb.ResendVerificationRequest{Email: "down@example.com"})
+	if st, _ := status.FromError(err); st.Code() != codes.Unavailable || errorReason(st) != ReasonUnavailable {
+		t.Errorf("failed send: expected Unavailable/%s, got %v", ReasonUnavailable, err)
+	}
+}
+
+func TestService_FollowsConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("auth_disabled: true\nupstream_timeout: 5s\ngateway_error_details: all\n")
+	cfg, err := config.Load(config.GatewayComponent, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	svc := NewServiceWithClient(cfg, &mockUserClient{})
+	defer svc.Close()
+
+	hasDeadline := func() bool {
+		var got bool
+		svc.upstreamTimeout(context.Background(), "/userpb.UserService/GetUser", nil, nil, nil,
+			func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				_, got = ctx.Deadline()
+				return nil
+			})
+		return got
+	}
+	debugErr := func() error {
+		st, _ := status.New(codes.NotFound, "no such user").WithDetails(&errdetails.DebugInfo{Detail: "x"})
+		return st.Err()
+	}
+	hasDebugInfo := func() bool {
+		st, _ := status.FromError(svc.translateError(context.Background(), debugErr()))
+		for _, d := range st.Details() {
+			if _, ok := d.(*errdetails.DebugInfo); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !hasDeadline() || !hasDebugInfo() {
+		t.Fatalf("initial config not applied: deadline=%v debugInfo=%v", hasDeadline(), hasDebugInfo())
+	}
+
+	write("auth_disabled: true\nupstream_timeout: 0s\ngateway_error_details: strip\n")
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if hasDeadline() || hasDebugInfo() {
+		t.Errorf("reloaded config not applied: deadline=%v debugInfo=%v", hasDeadline(), hasDebugInfo())
+	}
+}
@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mr1hm/grpc-demo/internal/auth"
+	"github.com/mr1hm/grpc-demo/internal/session"
+	"github.com/mr1hm/grpc-demo/internal/validation"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errSessionsDisabled answers the session methods when no secret is
+// configured to sign access tokens with
+var errSessionsDisabled = status.Error(codes.Unimplemented, "login is not enabled on this gateway")
+
+// tokens are the credentials Login and RefreshToken return
+type tokens struct {
+	access, refresh                   string
+	accessExpiresAt, refreshExpiresAt *timestamppb.Timestamp
+}
+
+// Login checks an email and password with the User service and starts a
+// session, returning its first access and refresh tokens
+func (s *Service) Login(ctx context.Context, req *gatewaypb.LoginRequest) (*gatewaypb.LoginResponse, error) {
+	s.logger(ctx).Debug("Logging in")
+	if s.sessions == nil {
+		return nil, errSessionsDisabled
+	}
+	var v validation.Violations
+	if req.Email == "" {
+		v.Add("email", "must not be empty")
+	}
+	if req.Password == "" {
+		v.Add("password", "must not be empty")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	userResp, err := s.userClient.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{
+		Email:    req.Email,
+		Password: req.Password,
+	})
+	if err != nil {
+		return nil, s.translateError(ctx, err)
+	}
+
+	sess, refresh := s.sessions.Start(userResp.UserId, s.live.Load().RefreshTokenTTL)
+	t, err := s.tokens(sess, refresh)
+	if err != nil {
+		return nil, err
+	}
+	s.logger(ctx).WithField("user_id", userResp.UserId).Info("Logged in")
+	return &gatewaypb.LoginResponse{
+		UserId:                sess.UserID,
+		AccessToken:           t.access,
+		AccessTokenExpiresAt:  t.accessExpiresAt,
+		RefreshToken:          t.refresh,
+		RefreshTokenExpiresAt: t.refreshExpiresAt,
+	}, nil
+}
+
+// RefreshToken redeems a refresh token for a new access token and the
+// refresh token replacing it. Redeeming a refresh token twice revokes its
+// session, as does the user no longer being active, for example because they
+// were suspended or deleted.
+func (s *Service) RefreshToken(ctx context.Context, req *gatewaypb.RefreshTokenRequest) (*gatewaypb.RefreshTokenResponse, error) {
+	s.logger(ctx).Debug("Refreshing token")
+	if s.sessions == nil {
+		return nil, errSessionsDisabled
+	}
+
+	sess, err := s.sessions.Lookup(req.RefreshToken)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	// The user may have been suspended or deleted since logging in. This is
+	// checked before redeeming the token, so that a failed check can be retried.
+	userResp, err := s.userClient.GetUser(ctx, &userpb.GetUserRequest{UserId: sess.UserID})
+	switch {
+	case status.Code(err) == codes.NotFound, err == nil && userResp.Status != userpb.UserStatus_USER_STATUS_ACTIVE:
+		s.sessions.RevokeSession(sess.ID)
+		s.logger(ctx).WithField("user_id", sess.UserID).Info("Revoked session of user no longer active")
+		return nil, status.Error(codes.Unauthenticated, session.ErrRevoked.Error())
+	case err != nil:
+		return nil, s.translateError(ctx, err)
+	}
+
+	sess, refresh, err := s.sessions.Rotate(req.RefreshToken, s.live.Load().RefreshTokenTTL)
+	if err != nil {
+		if errors.Is(err, session.ErrReused) {
+			s.logger(ctx).WithField("user_id", sess.UserID).Warn("Refresh token reused; revoked its session")
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	t, err := s.tokens(sess, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewaypb.RefreshTokenResponse{
+		UserId:                sess.UserID,
+		AccessToken:           t.access,
+		AccessTokenExpiresAt:  t.accessExpiresAt,
+		RefreshToken:          t.refresh,
+		RefreshTokenExpiresAt: t.refreshExpiresAt,
+	}, nil
+}
+
+// Logout revokes the session of a refresh token. Its refresh tokens stop
+// working at once, and so do its access tokens.
+func (s *Service) Logout(ctx context.Context, req *gatewaypb.LogoutRequest) (*gatewaypb.LogoutResponse, error) {
+	s.logger(ctx).Debug("Logging out")
+	if s.sessions == nil {
+		return nil, errSessionsDisabled
+	}
+	sess, err := s.sessions.Revoke(req.RefreshToken)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	s.logger(ctx).WithField("user_id", sess.UserID).Info("Logged out")
+	return &gatewaypb.LogoutResponse{}, nil
+}
+
+// tokens signs an access token for sess to go with its refresh token
+func (s *Service) tokens(sess session.Session, refresh string) (tokens, error) {
+	access, expiresAt, err := s.signer.Sign(&auth.Principal{Subject: sess.UserID, SessionID: sess.ID}, s.live.Load().AccessTokenTTL)
+	if err != nil {
+		return tokens{}, status.Errorf(codes.Internal, "sign access token: %v", err)
+	}
+	return tokens{
+		access:           access,
+		refresh:          refresh,
+		accessExpiresAt:  timestamppb.New(expiresAt),
+		refreshExpiresAt: timestamppb.New(sess.ExpiresAt),
+	}, nil
+}
@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/internal/auth"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/mail/mailtest"
+	"github.com/mr1hm/grpc-demo/internal/user"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionTest is a gateway with login enabled in front of a real User service
+type sessionTest struct {
+	client  gatewaypb.GatewayServiceClient
+	userSvc *user.Service
+	mailer  *mailtest.Recorder
+}
+
+func newSessionTest(t *testing.T) *sessionTest {
+	t.Helper()
+	cfg := config.New("127.0.0.1:0", "127.0.0.1:0")
+	cfg.SetOutput(io.Discard)
+	cfg.JWTHS256Secret = testSecret
+
+	mailer := mailtest.NewRecorder()
+	userSvc := user.NewServiceWithMailer(cfg, user.NewMemoryStore(), mailer)
+	t.Cleanup(func() { userSvc.Close() })
+	userConn, err := grpc.NewClient(serve(t, userSvc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { userConn.Close() })
+
+	svc := NewServiceWithClient(cfg, userpb.NewUserServiceClient(userConn))
+	t.Cleanup(func() { svc.Close() })
+	conn, err := grpc.NewClient(serve(t, svc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &sessionTest{client: gatewaypb.NewGatewayServiceClient(conn), userSvc: userSvc, mailer: mailer}
+}
+
+// register registers a user with password "correct horse" and, if verify is
+// set, redeems the emailed verification token, returning the user's ID
+func (st *sessionTest) register(t *testing.T, email string, verify bool) string {
+	t.Helper()
+	ctx := context.Background()
+	reg, err := st.client.RegisterUser(ctx, &gatewaypb.RegisterUserRequest{Name: "Ann", Email: email, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("RegisterUser failed: %v", err)
+	}
+	if !verify {
+		return reg.UserId
+	}
+	for _, msg := range st.mailer.Sent() {
+		for _, field := range strings.Fields(msg.Body) {
+			if strings.HasPrefix(field, reg.UserId+".") {
+				if _, err := st.client.VerifyEmail(ctx, &gatewaypb.VerifyEmailRequest{Token: field}); err != nil {
+					t.Fatalf("VerifyEmail failed: %v", err)
+				}
+				return reg.UserId
+			}
+		}
+	}
+	t.Fatalf("no verification token sent for %s", reg.UserId)
+	return ""
+}
+
+func withBearer(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), auth.AuthorizationHeader, "Bearer "+token)
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	st := newSessionTest(t)
+	client := st.client
+	userID := st.register(t, "ann@example.com", true)
+
+	if _, err := client.Login(ctx, &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "wrong horse"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Login with a wrong password: got %v, want Unauthenticated", err)
+	} else if st, _ := status.FromError(err); errorReason(st) != ReasonInvalidCredentials {
+		t.Errorf("Login with a wrong password: reason %q, want %q", errorReason(st), ReasonInvalidCredentials)
+	}
+
+	login, err := client.Login(ctx, &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if login.UserId != userID || login.AccessToken == "" || login.RefreshToken == "" {
+		t.Fatalf("Login = %+v", login)
+	}
+	if !login.AccessTokenExpiresAt.AsTime().Before(login.RefreshTokenExpiresAt.AsTime()) {
+		t.Errorf("access token expires at %v, after the refresh token at %v", login.AccessTokenExpiresAt.AsTime(), login.RefreshTokenExpiresAt.AsTime())
+	}
+	if _, err := client.GetUserProfile(withBearer(login.AccessToken), &gatewaypb.GetUserProfileRequest{UserId: userID}); err != nil {
+		t.Errorf("GetUserProfile with the access token: %v", err)
+	}
+
+	refreshed, err := client.RefreshToken(ctx, &gatewaypb.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if refreshed.RefreshToken == login.RefreshToken || refreshed.AccessToken == login.AccessToken {
+		t.Error("RefreshToken should rotate both tokens")
+	}
+
+	// Replaying the redeemed refresh token revokes the session, including
+	// its access tokens and the refresh token that replaced it
+	if _, err := client.RefreshToken(ctx, &gatewaypb.RefreshTokenRequest{RefreshToken: login.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("reused refresh token: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.RefreshToken(ctx, &gatewaypb.RefreshTokenRequest{RefreshToken: refreshed.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("refresh token of the revoked session: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(withBearer(refreshed.AccessToken), &gatewaypb.GetUserProfileRequest{UserId: userID}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("access token of the revoked session: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	st := newSessionTest(t)
+	client := st.client
+	st.register(t, "ann@example.com", true)
+	login := func() *gatewaypb.LoginResponse {
+		t.Helper()
+		resp, err := client.Login(ctx, &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+		if err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		return resp
+	}
+	phone, laptop := login(), login()
+
+	if _, err := client.Logout(ctx, &gatewaypb.LogoutRequest{RefreshToken: phone.RefreshToken}); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := client.Logout(ctx, &gatewaypb.LogoutRequest{RefreshToken: phone.RefreshToken}); err != nil {
+		t.Errorf("second Logout: %v", err)
+	}
+	if _, err := client.RefreshToken(ctx, &gatewaypb.RefreshTokenRequest{RefreshToken: phone.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("refresh after logout: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(withBearer(phone.AccessToken), &gatewaypb.GetUserProfileRequest{UserId: phone.UserId}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("access token after logout: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(withBearer(laptop.AccessToken), &gatewaypb.GetUserProfileRequest{UserId: laptop.UserId}); err != nil {
+		t.Errorf("other session after logout: %v", err)
+	}
+	if _, err := client.Logout(ctx, &gatewaypb.LogoutRequest{RefreshToken: "not a token"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Logout with an unknown token: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestRefreshToken_SuspendedUser(t *testing.T) {
+	ctx := context.Background()
+	st := newSessionTest(t)
+	client := st.client
+	userID := st.register(t, "ann@example.com", true)
+	login, err := client.Login(ctx, &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.userSvc.SuspendUser(ctx, &userpb.SuspendUserRequest{UserId: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.RefreshToken(ctx, &gatewaypb.RefreshTokenRequest{RefreshToken: login.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("refresh of a suspended user: got %v, want Unauthenticated", err)
+	}
+	if _, err := client.GetUserProfile(withBearer(login.AccessToken), &gatewaypb.GetUserProfileRequest{UserId: userID}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("access token of a suspended user's revoked session: got %v, want Unauthenticated", err)
+	}
+	_, err = client.Login(ctx, &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+	if st, _ := status.FromError(err); st.Code() != codes.PermissionDenied || errorReason(st) != ReasonUserSuspended {
+		t.Errorf("Login of a suspended user: expected PermissionDenied/%s, got %v", ReasonUserSuspended, err)
+	}
+}
+
+func TestLogin_Unverified(t *testing.T) {
+	st := newSessionTest(t)
+	st.register(t, "ann@example.com", false)
+
+	_, err := st.client.Login(context.Background(), &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+	if s, _ := status.FromError(err); s.Code() != codes.FailedPrecondition || errorReason(s) != ReasonEmailNotVerified {
+		t.Errorf("Login before verifying: expected FailedPrecondition/%s, got %v", ReasonEmailNotVerified, err)
+	}
+}
+
+func TestLogin_Disabled(t *testing.T) {
+	svc := newTestGatewayService(&mockUserClient{})
+	_, err := svc.Login(context.Background(), &gatewaypb.LoginRequest{Email: "ann@example.com", Password: "correct horse"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Login without jwt_hs256_secret: got %v, want Unimplemented", err)
+	}
+}
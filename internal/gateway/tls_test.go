@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/internal/certs"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/user"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestTLS(t *testing.T) {
+	_, files, err := certs.WriteDev(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New("127.0.0.1:0", "127.0.0.1:0")
+	cfg.SetOutput(io.Discard)
+	cfg.AuthDisabled = true
+	files.Configure(cfg)
+
+	userSvc := user.NewServiceWithStore(cfg, user.NewMemoryStore())
+	t.Cleanup(func() { userSvc.Close() })
+	userServer, err := userSvc.Start(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.UserServiceAddr = userServer.Addr().String()
+
+	gatewaySvc := NewService(cfg)
+	t.Cleanup(func() { gatewaySvc.Close() })
+	gatewayServer, err := gatewaySvc.Start(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Callers verify the gateway against the CA but present no certificate
+	callerTLS, err := certs.NewSource("", "", files.CA, gatewaySvc.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func(addr string, creds credentials.TransportCredentials) *grpc.ClientConn {
+		t.Helper()
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	secure := credentials.NewTLS(certs.ClientConfig(callerTLS))
+
+	t.Run("through the gateway", func(t *testing.T) {
+		client := gatewaypb.NewGatewayServiceClient(dial(gatewayServer.Addr().String(), secure))
+		resp, err := client.RegisterUser(context.Background(), &gatewaypb.RegisterUserRequest{Name: "Ann", Email: "ann@example.com"})
+		if err != nil {
+			t.Fatalf("RegisterUser failed: %v", err)
+		}
+		if _, err := client.GetUserProfile(context.Background(), &gatewaypb.GetUserProfileRequest{UserId: resp.UserId}); err != nil {
+			t.Errorf("GetUserProfile failed: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		addr  string
+		creds credentials.TransportCredentials
+	}{
+		{name: "plaintext to the gateway", addr: gatewayServer.Addr().String(), creds: insecure.NewCredentials()},
+		{name: "plaintext to the user service", addr: userServer.Addr().String(), creds: insecure.NewCredentials()},
+		{name: "user service without a client certificate", addr: userServer.Addr().String(), creds: secure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := userpb.NewUserServiceClient(dial(tt.addr, tt.creds))
+			_, err := client.GetUser(context.Background(), &userpb.GetUserRequest{UserId: "user-1"})
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("GetUser error = %v, want Unavailable", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/tracing/tracingtest"
+	"github.com/mr1hm/grpc-demo/internal/user"
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// serve serves server on an ephemeral local port until the test ends
+func serve(t *testing.T, server *grpc.Server) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestTracing_GetUserProfile(t *testing.T) {
+	exporter := tracingtest.NewInMemory()
+
+	cfg := config.New(":0", ":0")
+	cfg.SetOutput(io.Discard)
+	cfg.AuthDisabled = true
+	userSvc := user.NewServiceWithStore(cfg, user.NewMemoryStore())
+	t.Cleanup(func() { userSvc.Close() })
+	created, err := userSvc.CreateUser(context.Background(), &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	cfg.UserServiceAddr = serve(t, userSvc.NewServer())
+
+	gatewaySvc := NewService(cfg)
+	t.Cleanup(func() { gatewaySvc.Close() })
+	conn, err := grpc.NewClient(serve(t, gatewaySvc.NewServer()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	exporter.Reset()
+	_, err = gatewaypb.NewGatewayServiceClient(conn).GetUserProfile(context.Background(), &gatewaypb.GetUserProfileRequest{UserId: created.UserId})
+	if err != nil {
+		t.Fatalf("GetUserProfile failed: %v", err)
+	}
+
+	// Each span's parent, from the gateway's server span down to the store
+	chain := []struct {
+		name string
+		kind trace.SpanKind
+	}{
+		{"gatewaypb.GatewayService/GetUserProfile", trace.SpanKindServer},
+		{"userpb.UserService/GetUser", trace.SpanKindClient},
+		{"userpb.UserService/GetUser", trace.SpanKindServer},
+		{"UserStore.Get", trace.SpanKindInternal},
+	}
+	spans := exporter.GetSpans()
+	var parent *tracetest.SpanStub
+	for _, want := range chain {
+		span := findSpan(spans, want.name, want.kind)
+		if span == nil {
+			t.Fatalf("no %s span %q among %d spans", want.kind, want.name, len(spans))
+		}
+		if parent == nil {
+			if span.Parent.IsValid() {
+				t.Errorf("%s span %q has a parent, want a root span", want.kind, want.name)
+			}
+		} else if span.Parent.SpanID() != parent.SpanContext.SpanID() || span.SpanContext.TraceID() != parent.SpanContext.TraceID() {
+			t.Errorf("%s span %q is not a child of %q", want.kind, want.name, parent.Name)
+		}
+		parent = span
+	}
+}
+
+func findSpan(spans tracetest.SpanStubs, name string, kind trace.SpanKind) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name && spans[i].SpanKind == kind {
+			return &spans[i]
+		}
+	}
+	return nil
+}
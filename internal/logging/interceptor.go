@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Fields set on every per-request logger and on the line logged per call
+const (
+	MethodField    = "method"
+	PeerField      = "peer"
+	RequestIDField = "request_id"
+	CodeField      = "code"
+	LatencyField   = "latency_ms"
+	TraceIDField   = "trace_id"
+)
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying log, the logger of the current request
+func NewContext(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// FromContext returns the request logger in ctx, or fallback when there is
+// none. The request's fields are added to fallback so that a package logger's
+// own level and package field are kept.
+func FromContext(ctx context.Context, fallback *logrus.Entry) *logrus.Entry {
+	log, ok := ctx.Value(contextKey{}).(*logrus.Entry)
+	if !ok {
+		return fallback
+	}
+	return fallback.WithFields(log.Data)
+}
+
+// UnaryServerInterceptor gives each call a request ID, taken from the
+// caller's RequestIDHeader or generated, and puts it and a logger carrying the
+// method, peer and request ID in the call's context. The ID is returned in the
+// response headers and, for failed calls, as a RequestInfo error detail. One
+// line is logged per call with its status code and latency.
+func UnaryServerInterceptor(log *logrus.Entry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		id := incomingRequestID(ctx)
+		ctx = WithRequestID(ctx, id)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+		reqLog := requestLogger(ctx, log, info.FullMethod)
+		resp, err := handler(NewContext(ctx, reqLog), req)
+		err = withRequestInfo(err, id)
+		logCall(reqLog, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(log *logrus.Entry) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		id := incomingRequestID(ss.Context())
+		ctx := WithRequestID(ss.Context(), id)
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		reqLog := requestLogger(ctx, log, info.FullMethod)
+		err := handler(srv, &loggedStream{ServerStream: ss, ctx: NewContext(ctx, reqLog)})
+		err = withRequestInfo(err, id)
+		logCall(reqLog, info.FullMethod, start, err)
+		return err
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
+
+func requestLogger(ctx context.Context, log *logrus.Entry, method string) *logrus.Entry {
+	fields := logrus.Fields{
+		MethodField:    method,
+		RequestIDField: RequestID(ctx),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields[PeerField] = p.Addr.String()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields[TraceIDField] = sc.TraceID().String()
+	}
+	return log.WithFields(fields)
+}
+
+// healthMethodPrefix starts the methods of grpc.health.v1.Health, which
+// orchestrators call every few seconds
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// logCall writes the per-call line at a level matching the status code:
+// server faults at error, caller mistakes at warn, everything else at info.
+// Successful health checks are logged at debug so probes do not drown out
+// real traffic.
+func logCall(log *logrus.Entry, method string, start time.Time, err error) {
+	code := status.Code(err)
+	entry := log.WithFields(logrus.Fields{
+		CodeField:    code.String(),
+		LatencyField: float64(time.Since(start).Microseconds()) / 1000,
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	level := codeLevel(code)
+	if code == codes.OK && strings.HasPrefix(method, healthMethodPrefix) {
+		level = logrus.DebugLevel
+	}
+	entry.Log(level, "finished call")
+}
+
+func codeLevel(code codes.Code) logrus.Level {
+	switch code {
+	case codes.OK, codes.Canceled, codes.NotFound, codes.AlreadyExists:
+		return logrus.InfoLevel
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal,
+		codes.Unavailable, codes.DataLoss:
+		return logrus.ErrorLevel
+	default:
+		return logrus.WarnLevel
+	}
+}
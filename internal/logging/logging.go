@@ -0,0 +1,124 @@
+// Package logging builds structured, per-package and per-request loggers on
+// top of the logrus.Logger embedded in config.Config, and redacts the proto
+// fields marked sensitive from logged messages
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Output formats accepted by Formatter
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Formatter returns the logrus formatter for format
+func Formatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case FormatText:
+		return &logrus.TextFormatter{}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("%q is not a log format (want %q or %q)", format, FormatText, FormatJSON)
+	}
+}
+
+// ParseLevels parses per-package levels written as "user=debug,gateway=warn"
+func ParseLevels(s string) (map[string]logrus.Level, error) {
+	levels := make(map[string]logrus.Level)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pkg, name, ok := strings.Cut(item, "=")
+		pkg = strings.TrimSpace(pkg)
+		if !ok || pkg == "" {
+			return nil, fmt.Errorf("%q is not package=level", item)
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", item, err)
+		}
+		levels[pkg] = level
+	}
+	return levels, nil
+}
+
+// FormatLevels is the inverse of ParseLevels, with packages sorted by name
+func FormatLevels(levels map[string]logrus.Level) string {
+	items := make([]string, 0, len(levels))
+	for pkg, level := range levels {
+		items = append(items, pkg+"="+level.String())
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// PackageField names the package that wrote a log line
+const PackageField = "package"
+
+// Loggers hands out one logger per package. Each shares the root logger's
+// output, formatter and hooks but has its own level, which defaults to the
+// root's.
+type Loggers struct {
+	root *logrus.Logger
+
+	mu        sync.Mutex
+	overrides map[string]logrus.Level
+	loggers   map[string]*logrus.Logger
+}
+
+// NewLoggers creates package loggers derived from root
+func NewLoggers(root *logrus.Logger) *Loggers {
+	return &Loggers{
+		root:    root,
+		loggers: make(map[string]*logrus.Logger),
+	}
+}
+
+// For returns the logger of pkg, tagged with PackageField
+func (l *Loggers) For(pkg string) *logrus.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	logger, ok := l.loggers[pkg]
+	if !ok {
+		logger = logrus.New()
+		l.loggers[pkg] = logger
+		l.sync(pkg, logger)
+	}
+	return logger.WithField(PackageField, pkg)
+}
+
+// SetLevels replaces the per-package overrides and brings every package
+// logger in line with the root logger's current output, formatter and level
+func (l *Loggers) SetLevels(overrides map[string]logrus.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.overrides = overrides
+	for pkg, logger := range l.loggers {
+		l.sync(pkg, logger)
+	}
+}
+
+// sync copies the root's settings onto a package logger; l.mu must be held
+func (l *Loggers) sync(pkg string, logger *logrus.Logger) {
+	logger.SetOutput(l.root.Out)
+	logger.SetFormatter(l.root.Formatter)
+	logger.ReplaceHooks(l.root.Hooks)
+	logger.ExitFunc = l.root.ExitFunc
+	level, ok := l.overrides[pkg]
+	if !ok {
+		level = l.root.GetLevel()
+	}
+	logger.SetLevel(level)
+}
@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// jsonLogger returns package loggers writing JSON lines to the returned buffer
+func jsonLogger(t *testing.T) (*Loggers, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	root := logrus.New()
+	root.SetOutput(&buf)
+	root.SetFormatter(&logrus.JSONFormatter{})
+	return NewLoggers(root), &buf
+}
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestParseLevels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "user=debug", want: "user=debug"},
+		{in: " gateway = WARN , user=trace,", want: "gateway=warning,user=trace"},
+		{in: "user", wantErr: true},
+		{in: "=debug", wantErr: true},
+		{in: "user=loud", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			levels, err := ParseLevels(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", levels)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := FormatLevels(levels); got != tt.want {
+				t.Errorf("FormatLevels = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggers_PackageLevels(t *testing.T) {
+	loggers, buf := jsonLogger(t)
+	user, gateway := loggers.For("user"), loggers.For("gateway")
+
+	loggers.SetLevels(map[string]logrus.Level{"user": logrus.DebugLevel})
+	user.Debug("user debug")
+	gateway.Debug("gateway debug")
+	gateway.Info("gateway info")
+
+	got := lines(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2: %v", len(got), got)
+	}
+	for i, want := range []struct{ pkg, msg string }{{"user", "user debug"}, {"gateway", "gateway info"}} {
+		if got[i][PackageField] != want.pkg || got[i]["msg"] != want.msg {
+			t.Errorf("line %d = %v, want package %s and msg %q", i, got[i], want.pkg, want.msg)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		requestID string
+		err       error
+		wantLevel string
+		wantCode  string
+	}{
+		{name: "ok with caller's request ID", requestID: "req-1", wantLevel: "info", wantCode: "OK"},
+		{name: "client error", err: status.Error(codes.InvalidArgument, "bad"), wantLevel: "warning", wantCode: "InvalidArgument"},
+		{name: "server error", err: status.Error(codes.Internal, "boom"), wantLevel: "error", wantCode: "Internal"},
+		{name: "health check", method: "/grpc.health.v1.Health/Check", wantLevel: "debug", wantCode: "OK"},
+		{name: "failed health check", method: "/grpc.health.v1.Health/Check", err: status.Error(codes.NotFound, "unknown service"), wantLevel: "info", wantCode: "NotFound"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loggers, buf := jsonLogger(t)
+			loggers.SetLevels(map[string]logrus.Level{"user": logrus.DebugLevel})
+			intercept := UnaryServerInterceptor(loggers.For("user"))
+
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+			if tt.requestID != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDHeader, tt.requestID))
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUser"}
+			if tt.method != "" {
+				info.FullMethod = tt.method
+			}
+			var handlerID any
+			_, err := intercept(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+				handlerID = FromContext(ctx, loggers.For("user")).Data[RequestIDField]
+				return nil, tt.err
+			})
+			if status.Code(err) != status.Code(tt.err) {
+				t.Fatalf("interceptor returned %v, want %v", err, tt.err)
+			}
+
+			got := lines(t, buf)
+			if len(got) != 1 {
+				t.Fatalf("got %d lines, want 1: %v", len(got), got)
+			}
+			line := got[0]
+			if line["level"] != tt.wantLevel || line[CodeField] != tt.wantCode {
+				t.Errorf("level, code = %v, %v; want %s, %s", line["level"], line[CodeField], tt.wantLevel, tt.wantCode)
+			}
+			if line[MethodField] != info.FullMethod || line[PeerField] != "10.0.0.1:4000" || line[PackageField] != "user" {
+				t.Errorf("line is missing call fields: %v", line)
+			}
+			if _, ok := line[LatencyField].(float64); !ok {
+				t.Errorf("latency = %v, want a number", line[LatencyField])
+			}
+			id, _ := line[RequestIDField].(string)
+			if id == "" || (tt.requestID != "" && id != tt.requestID) {
+				t.Errorf("request ID = %q, want %q", id, tt.requestID)
+			}
+			if handlerID != id {
+				t.Errorf("handler saw request ID %v, logged %q", handlerID, id)
+			}
+		})
+	}
+}
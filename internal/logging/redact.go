@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/mr1hm/grpc-demo/proto/redactpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// redactedPrefix starts every value written by RedactString
+const redactedPrefix = "redacted:"
+
+// RedactString replaces a sensitive value with a short hash of it, so log
+// lines about the same value can still be correlated. Empty values stay empty.
+func RedactString(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return redactedPrefix + hex.EncodeToString(sum[:6])
+}
+
+// Redact returns a copy of m in which every field marked with the
+// (redactpb.sensitive) option, at any depth, is redacted: strings are
+// replaced by RedactString and other kinds are cleared. m is not modified.
+func Redact(m proto.Message) proto.Message {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return m
+	}
+	out := proto.Clone(m)
+	redact(out.ProtoReflect())
+	return out
+}
+
+func redact(m protoreflect.Message) {
+	type field struct {
+		fd protoreflect.FieldDescriptor
+		v  protoreflect.Value
+	}
+	// Collected first: the message must not change while Range runs
+	var fields []field
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields = append(fields, field{fd, v})
+		return true
+	})
+
+	for _, f := range fields {
+		fd, v := f.fd, f.v
+		switch {
+		case sensitive(fd):
+			redactField(m, fd, v)
+		case fd.IsMap():
+			if isMessage(fd.MapValue()) {
+				v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					redact(v.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if isMessage(fd) {
+				for l, i := v.List(), 0; i < l.Len(); i++ {
+					redact(l.Get(i).Message())
+				}
+			}
+		case isMessage(fd):
+			redact(v.Message())
+		}
+	}
+}
+
+// redactField redacts the value v of a sensitive field
+func redactField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	if fd.Kind() != protoreflect.StringKind || fd.IsMap() {
+		m.Clear(fd)
+		return
+	}
+	if fd.IsList() {
+		for l, i := v.List(), 0; i < l.Len(); i++ {
+			l.Set(i, protoreflect.ValueOfString(RedactString(l.Get(i).String())))
+		}
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfString(RedactString(v.String())))
+}
+
+func sensitive(fd protoreflect.FieldDescriptor) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	return ok && proto.GetExtension(opts, redactpb.E_Sensitive).(bool)
+}
+
+func isMessage(fd protoreflect.FieldDescriptor) bool {
+	return fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind
+}
+
+// Proto wraps m for use as a log field. It is written as JSON, nested in JSON
+// output, with sensitive fields redacted.
+func Proto(m proto.Message) any {
+	return loggedProto{m}
+}
+
+type loggedProto struct {
+	m proto.Message
+}
+
+func (p loggedProto) MarshalJSON() ([]byte, error) {
+	if p.m == nil || !p.m.ProtoReflect().IsValid() {
+		return []byte("null"), nil
+	}
+	return protojson.Marshal(Redact(p.m))
+}
+
+func (p loggedProto) String() string {
+	b, err := p.MarshalJSON()
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(b)
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/proto/gatewaypb"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRedact(t *testing.T) {
+	req := &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com"}
+	got := Redact(req).(*userpb.CreateUserRequest)
+
+	if got.Name != RedactString("Ann") || got.Email != RedactString("ann@example.com") {
+		t.Errorf("Redact = %v, want hashed name and email", got)
+	}
+	if !strings.HasPrefix(got.Email, redactedPrefix) {
+		t.Errorf("redacted email %q lacks prefix %q", got.Email, redactedPrefix)
+	}
+	if req.Name != "Ann" || req.Email != "ann@example.com" {
+		t.Errorf("Redact modified its argument: %v", req)
+	}
+
+	list := &userpb.ListUsersResponse{
+		Users: []*userpb.User{
+			{UserId: "user-1", Name: "Ann", Email: "ann@example.com"},
+			{UserId: "user-2", Name: "Bob", Email: ""},
+		},
+		NextPageToken: "next",
+	}
+	want := &userpb.ListUsersResponse{
+		Users: []*userpb.User{
+			{UserId: "user-1", Name: RedactString("Ann"), Email: RedactString("ann@example.com")},
+			{UserId: "user-2", Name: RedactString("Bob"), Email: ""},
+		},
+		NextPageToken: "next",
+	}
+	if got := Redact(list); !proto.Equal(got, want) {
+		t.Errorf("Redact nested = %v, want %v", got, want)
+	}
+
+	if got := Redact((*userpb.User)(nil)); got.(*userpb.User) != nil {
+		t.Errorf("Redact(nil) = %v, want nil", got)
+	}
+}
+
+func TestProto(t *testing.T) {
+	req := &gatewaypb.RegisterUserRequest{Name: "Ann", Email: "ann@example.com"}
+
+	for _, formatter := range []logrus.Formatter{&logrus.JSONFormatter{}, &logrus.TextFormatter{DisableColors: true}} {
+		t.Run(fmt.Sprintf("%T", formatter), func(t *testing.T) {
+			var buf bytes.Buffer
+			log := logrus.New()
+			log.SetOutput(&buf)
+			log.SetFormatter(formatter)
+			log.WithField("request", Proto(req)).Info("Registering user")
+
+			out := buf.String()
+			if strings.Contains(out, "Ann") || strings.Contains(out, "ann@example.com") {
+				t.Errorf("log line leaks PII: %s", out)
+			}
+			if !strings.Contains(out, RedactString("ann@example.com")) {
+				t.Errorf("log line lacks redacted email: %s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRequestID(t *testing.T) {
+	loggers, buf := jsonLogger(t)
+	// Successful health checks are logged at debug
+	loggers.SetLevels(map[string]logrus.Level{"test": logrus.DebugLevel})
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(loggers.For("test"))))
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthSrv)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		service string
+		wantID  string // Empty expects a generated ID
+		wantErr bool
+	}{
+		{name: "propagated from context", ctx: WithRequestID(context.Background(), "req-42"), service: "up", wantID: "req-42"},
+		{name: "explicit metadata wins", ctx: metadata.AppendToOutgoingContext(WithRequestID(context.Background(), "req-42"), RequestIDHeader, "req-md"), service: "up", wantID: "req-md"},
+		{name: "generated", ctx: context.Background(), service: "up"},
+		{name: "invalid ID replaced", ctx: metadata.AppendToOutgoingContext(context.Background(), RequestIDHeader, "has space"), service: "up"},
+		{name: "in error details", ctx: WithRequestID(context.Background(), "req-err"), service: "down", wantID: "req-err", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			var header metadata.MD
+			_, err := client.Check(tt.ctx, &healthpb.HealthCheckRequest{Service: tt.service}, grpc.Header(&header))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			ids := header.Get(RequestIDHeader)
+			if len(ids) != 1 {
+				t.Fatalf("response header %s = %v, want one value", RequestIDHeader, ids)
+			}
+			id := ids[0]
+			if tt.wantID != "" && id != tt.wantID {
+				t.Errorf("request ID = %q, want %q", id, tt.wantID)
+			}
+			if tt.wantID == "" && (len(id) != 32 || strings.Contains(id, " ")) {
+				t.Errorf("request ID = %q, want a generated one", id)
+			}
+
+			lines := lines(t, buf)
+			if len(lines) != 1 || lines[0][RequestIDField] != id {
+				t.Errorf("log lines %v, want one with request ID %q", lines, id)
+			}
+
+			if tt.wantErr {
+				var info *errdetails.RequestInfo
+				for _, d := range status.Convert(err).Details() {
+					if d, ok := d.(*errdetails.RequestInfo); ok {
+						info = d
+					}
+				}
+				if info == nil || info.RequestId != id {
+					t.Errorf("error details %v, want RequestInfo with ID %q", status.Convert(err).Details(), id)
+				}
+			}
+		})
+	}
+}
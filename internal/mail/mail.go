@@ -0,0 +1,104 @@
+// Package mail sends outbound email on behalf of the services
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a single outbound email
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FileMailer "sends" mail by appending it as a JSON line to an outbox file and
+// logging that it did, with the recipient redacted and without the body. It
+// never reaches a real inbox; use it for local development. It keeps
+// nothing in memory; tests that read sent mail use mailtest.Recorder.
+type FileMailer struct {
+	log  logrus.FieldLogger
+	path string
+
+	mu sync.Mutex // Serializes appends to the outbox file
+}
+
+// NewFileMailer writes messages to the outbox file at path. With an empty
+// path messages are only logged, and are otherwise dropped.
+func NewFileMailer(log logrus.FieldLogger, path string) *FileMailer {
+	return &FileMailer{log: log, path: path}
+}
+
+// Send appends msg to the outbox file and logs its redacted envelope
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.path != "" {
+		if err := appendJSONLine(m.path, msg); err != nil {
+			return fmt.Errorf("write outbox: %w", err)
+		}
+	}
+	// The recipient is personal data and the body may carry names and
+	// live tokens, so neither reaches the log; read the outbox for those
+	log := m.log.WithFields(logrus.Fields{"to": logging.RedactString(msg.To), "subject": msg.Subject})
+	if id := logging.RequestID(ctx); id != "" {
+		log = log.WithField(logging.RequestIDField, id)
+	}
+	log.Info("Sent mail")
+	return nil
+}
+
+// ReadOutbox returns the messages in an outbox file written by FileMailer
+func ReadOutbox(path string) ([]Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var msgs []Message
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("read outbox %s: %w", path, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func appendJSONLine(path string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
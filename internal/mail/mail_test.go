@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/internal/logging"
+	"github.com/sirupsen/logrus"
+)
+
+func TestFileMailer(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	m := NewFileMailer(log, path)
+
+	msgs := []Message{
+		{To: "ann@example.com", Subject: "Hello", Body: "first"},
+		{To: "bob@example.com", Subject: "Again", Body: "second\nline"},
+	}
+	for _, msg := range msgs {
+		if err := m.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	got, err := ReadOutbox(path)
+	if err != nil {
+		t.Fatalf("ReadOutbox failed: %v", err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("ReadOutbox returned %d messages, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i].To != msgs[i].To || got[i].Subject != msgs[i].Subject || got[i].Body != msgs[i].Body {
+			t.Errorf("ReadOutbox[%d] = %+v, want %+v", i, got[i], msgs[i])
+		}
+		if got[i].SentAt.IsZero() {
+			t.Errorf("ReadOutbox[%d].SentAt not set", i)
+		}
+	}
+}
+
+func TestFileMailer_LogOnly(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	m := NewFileMailer(log, "")
+
+	if err := m.Send(context.Background(), Message{To: "ann@example.com"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Sent mail") {
+		t.Errorf("log = %q, want a line recording the send", buf.String())
+	}
+}
+
+func TestFileMailer_LogIsRedacted(t *testing.T) {
+	msg := Message{
+		To:      "ann@example.com",
+		Subject: "Verify your email address",
+		Body:    "Hi Ann,\n\nUse this token to verify your email address:\n\nuser-1.s3cr3t-token\n",
+	}
+	for _, formatter := range []logrus.Formatter{&logrus.JSONFormatter{}, &logrus.TextFormatter{DisableColors: true}} {
+		t.Run(fmt.Sprintf("%T", formatter), func(t *testing.T) {
+			var buf bytes.Buffer
+			log := logrus.New()
+			log.SetOutput(&buf)
+			log.SetFormatter(formatter)
+
+			if err := NewFileMailer(log, "").Send(context.Background(), msg); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			out := buf.String()
+			for _, leak := range []string{"ann@example.com", "Ann", "s3cr3t-token"} {
+				if strings.Contains(out, leak) {
+					t.Errorf("log line leaks %q: %s", leak, out)
+				}
+			}
+			if !strings.Contains(out, logging.RedactString("ann@example.com")) || !strings.Contains(out, "Verify your email address") {
+				t.Errorf("log line lacks the redacted recipient or the subject: %s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package mailtest provides a Mailer that records messages for tests to read
+package mailtest
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/mail"
+)
+
+// Recorder keeps every message it is sent in memory
+type Recorder struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+// NewRecorder returns a Recorder that has sent nothing yet
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send records msg
+func (r *Recorder) Send(ctx context.Context, msg mail.Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+// Sent returns every message sent so far, oldest first
+func (r *Recorder) Sent() []mail.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.sent)
+}
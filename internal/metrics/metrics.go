@@ -0,0 +1,101 @@
+// Package metrics exposes Prometheus metrics for the services: per-method
+// call counts, status codes and latencies for every gRPC server and client,
+// plus whatever domain collectors a service provides.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where Serve exposes metrics
+const Path = "/metrics"
+
+// Server records grpc_server_* metrics for every server that installs its
+// interceptors. Servers in one process share it and are told apart by the
+// grpc_service label.
+var Server = grpcprom.NewServerMetrics(grpcprom.WithServerHandlingTimeHistogram())
+
+// Client records grpc_client_* metrics for every client connection that
+// installs its interceptors
+var Client = grpcprom.NewClientMetrics(grpcprom.WithClientHandlingTimeHistogram())
+
+// NewRegistry returns a registry holding the gRPC metrics, Go runtime and
+// process metrics, and extra
+func NewRegistry(extra ...prometheus.Collector) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Server,
+		Client,
+	)
+	reg.MustRegister(extra...)
+	return reg
+}
+
+// readHeaderTimeout drops scrapers that connect but never send a request
+const readHeaderTimeout = 10 * time.Second
+
+// Endpoint is the metrics HTTP server serving in its own goroutine. Stop it
+// with the embedded Close or Shutdown.
+type Endpoint struct {
+	*http.Server
+	lis  net.Listener
+	done chan struct{}
+	err  error
+}
+
+// Serve exposes the metrics of NewRegistry(extra...) over HTTP at Path on
+// cfg.MetricsPort, in a goroutine. It returns nil when MetricsPort is empty.
+func Serve(cfg *config.Config, extra ...prometheus.Collector) (*Endpoint, error) {
+	if cfg.MetricsPort == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", cfg.MetricsPort)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", cfg.MetricsPort, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.HandlerFor(NewRegistry(extra...), promhttp.HandlerOpts{}))
+	e := &Endpoint{
+		Server: &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout},
+		lis:    lis,
+		done:   make(chan struct{}),
+	}
+	cfg.Infof("Serving metrics on %s%s", lis.Addr(), Path)
+	go func() {
+		if err := e.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			e.err = err
+		}
+		close(e.done)
+	}()
+	return e, nil
+}
+
+// Addr returns the address the endpoint listens on
+func (e *Endpoint) Addr() net.Addr {
+	return e.lis.Addr()
+}
+
+// Done is closed once the endpoint has stopped serving
+func (e *Endpoint) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait blocks until the endpoint stops serving. It returns nil when the
+// endpoint was closed, and the reason otherwise, such as a listener that failed.
+func (e *Endpoint) Wait() error {
+	<-e.done
+	return e.err
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRPCMetrics(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(Server.UnaryServerInterceptor()))
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthSrv)
+	Server.InitializeMetrics(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(Client.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"up", "up", "down"} {
+		client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	}
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(NewRegistry(), promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	labels := `grpc_method="Check",grpc_service="grpc.health.v1.Health",grpc_type="unary"`
+	for _, want := range []string{
+		`grpc_server_started_total{` + labels + `} 3`,
+		`grpc_server_handled_total{grpc_code="OK",` + labels + `} 2`,
+		`grpc_server_handled_total{grpc_code="NotFound",` + labels + `} 1`,
+		`grpc_server_handling_seconds_count{` + labels + `} 3`,
+		`grpc_client_handled_total{grpc_code="OK",` + labels + `} 2`,
+		`grpc_client_handling_seconds_count{` + labels + `} 3`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name    string
+		stop    func(e *Endpoint)
+		wantErr bool
+	}{
+		{name: "close", stop: func(e *Endpoint) { e.Close() }},
+		{name: "shutdown", stop: func(e *Endpoint) { e.Shutdown(context.Background()) }},
+		{name: "listener fails", stop: func(e *Endpoint) { e.lis.Close() }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New(":0", ":0")
+			cfg.MetricsPort = "127.0.0.1:0"
+			e, err := Serve(cfg)
+			if err != nil {
+				t.Fatalf("Serve failed: %v", err)
+			}
+			t.Cleanup(func() { e.Close() })
+
+			resp, err := http.Get("http://" + e.Addr().String() + Path)
+			if err != nil {
+				t.Fatalf("scrape failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("scrape status = %d, want 200", resp.StatusCode)
+			}
+
+			tt.stop(e)
+			select {
+			case <-e.Done():
+			case <-time.After(time.Second):
+				t.Fatal("endpoint still serving")
+			}
+			if err := e.Wait(); (err != nil) != tt.wantErr {
+				t.Errorf("Wait() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServe_Errors(t *testing.T) {
+	cfg := config.New(":0", ":0")
+	cfg.MetricsPort = ""
+	if e, err := Serve(cfg); e != nil || err != nil {
+		t.Errorf("Serve with metrics disabled = %v, %v; want nil, nil", e, err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	cfg.MetricsPort = lis.Addr().String()
+	if _, err := Serve(cfg); err == nil {
+		t.Error("Serve on a port in use succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+// Package server runs gRPC servers in the background and reports how they
+// end, so a process can serve several at once and notice when one fails
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc"
+)
+
+// Server is a gRPC server serving in its own goroutine. Stop it with the
+// embedded Stop or GracefulStop.
+type Server struct {
+	*grpc.Server
+	lis  net.Listener
+	done chan struct{}
+	err  error
+}
+
+// Serve starts serving server on lis in a new goroutine. When ctx is done the
+// server is stopped immediately; callers wanting an orderly shutdown call
+// GracefulStop first.
+func Serve(ctx context.Context, server *grpc.Server, lis net.Listener) *Server {
+	s := &Server{Server: server, lis: lis, done: make(chan struct{})}
+	go func() {
+		s.err = server.Serve(lis)
+		close(s.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Stop()
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+// Addr returns the address the server listens on, such as the port picked
+// for an ephemeral ":0"
+func (s *Server) Addr() net.Addr {
+	return s.lis.Addr()
+}
+
+// DialAddr returns an address a client on the same host can dial to reach the
+// server: Addr, with an unspecified IP such as [::] replaced by localhost
+func (s *Server) DialAddr() string {
+	addr, ok := s.Addr().(*net.TCPAddr)
+	if !ok {
+		return s.Addr().String()
+	}
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// Done is closed once the server has stopped serving
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the server stops serving. It returns nil when the server
+// was stopped, and the reason otherwise, such as a listener that failed.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name    string
+		stop    func(s *Server, lis *bufconn.Listener, cancel context.CancelFunc)
+		wantErr bool
+	}{
+		{name: "graceful stop", stop: func(s *Server, _ *bufconn.Listener, _ context.CancelFunc) { s.GracefulStop() }},
+		{name: "stop", stop: func(s *Server, _ *bufconn.Listener, _ context.CancelFunc) { s.Stop() }},
+		{name: "context done", stop: func(_ *Server, _ *bufconn.Listener, cancel context.CancelFunc) { cancel() }},
+		{name: "listener fails", stop: func(_ *Server, lis *bufconn.Listener, _ context.CancelFunc) { lis.Close() }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			lis := bufconn.Listen(1 << 20)
+			s := Serve(ctx, grpc.NewServer(), lis)
+			t.Cleanup(s.Stop)
+
+			select {
+			case <-s.Done():
+				t.Fatal("server stopped before it was told to")
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			tt.stop(s, lis, cancel)
+			select {
+			case <-s.Done():
+			case <-time.After(time.Second):
+				t.Fatal("server still serving")
+			}
+			if err := s.Wait(); (err != nil) != tt.wantErr {
+				t.Errorf("Wait() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialAddr(t *testing.T) {
+	tests := []struct {
+		listen   string
+		wantHost string
+	}{
+		{listen: ":0", wantHost: "localhost"},
+		{listen: "0.0.0.0:0", wantHost: "localhost"},
+		{listen: "127.0.0.1:0", wantHost: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			lis, err := net.Listen("tcp", tt.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := Serve(context.Background(), grpc.NewServer(), lis)
+			t.Cleanup(s.Stop)
+
+			port := strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+			if got, want := s.DialAddr(), net.JoinHostPort(tt.wantHost, port); got != want {
+				t.Errorf("DialAddr() = %q, want %q", got, want)
+			}
+		})
+	}
+}
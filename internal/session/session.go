@@ -0,0 +1,218 @@
+// Package session keeps the refresh tokens of gateway login sessions. Each
+// refresh token is redeemable once, for its replacement; redeeming one a
+// second time means it was stolen, so the whole session is revoked.
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often at most a Store drops expired sessions
+const sweepInterval = time.Minute
+
+// retainUsed is how many redeemed refresh tokens a session keeps to detect
+// their reuse. Older ones are forgotten: presenting one fails with ErrInvalid
+// instead of revoking the session, so a long-lived session that refreshes
+// often does not grow without bound.
+const retainUsed = 8
+
+// Refresh token failures
+var (
+	ErrInvalid = errors.New("refresh token is invalid")
+	ErrExpired = errors.New("refresh token has expired")
+	ErrRevoked = errors.New("session has been revoked")
+	ErrReused  = errors.New("refresh token was already used; session revoked")
+)
+
+// Session is a login of one user, lasting across refresh token rotations
+type Session struct {
+	// ID names the session in the access tokens issued for it
+	ID     string
+	UserID string
+	// ExpiresAt is when the current refresh token expires
+	ExpiresAt time.Time
+}
+
+// family is a session and the refresh tokens issued to it
+type family struct {
+	Session
+	revoked bool
+	used    []string // Hashes of the redeemed tokens still kept, oldest first
+}
+
+// token is an issued refresh token, keyed by its hash
+type token struct {
+	family    *family
+	expiresAt time.Time
+	used      bool
+}
+
+// Store keeps sessions in memory. Only hashes of refresh tokens are held, so
+// a memory dump cannot be used to refresh sessions. Sessions are lost when
+// the process exits, which logs every user out, and are not shared between
+// processes: a refresh token is only known to the gateway that issued it, so
+// the gateway must run as a single instance.
+type Store struct {
+	mu         sync.Mutex
+	families   map[string]*family
+	tokens     map[string]*token
+	now        func() time.Time
+	sweptAt    time.Time
+	sweepEvery time.Duration
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		families:   make(map[string]*family),
+		tokens:     make(map[string]*token),
+		now:        time.Now,
+		sweepEvery: sweepInterval,
+	}
+}
+
+// Start begins a session for userID and returns its first refresh token,
+// valid for ttl
+func (s *Store) Start(userID string, ttl time.Duration) (Session, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	f := &family{Session: Session{ID: randomString(16), UserID: userID}}
+	s.families[f.ID] = f
+	return f.Session, s.issue(f, now, ttl)
+}
+
+// Lookup returns the session of refresh without redeeming it
+func (s *Store) Lookup(refresh string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[hashToken(refresh)]
+	switch {
+	case !ok:
+		return Session{}, ErrInvalid
+	case t.family.revoked:
+		return Session{}, ErrRevoked
+	case !t.used && !s.now().Before(t.expiresAt):
+		return Session{}, ErrExpired
+	}
+	return t.family.Session, nil
+}
+
+// Rotate redeems refresh and returns its session and the refresh token that
+// replaces it, valid for ttl. Redeeming a token a second time revokes its
+// session and returns ErrReused. The session is returned with every error
+// but ErrInvalid.
+func (s *Store) Rotate(refresh string, ttl time.Duration) (Session, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	hash := hashToken(refresh)
+	t, ok := s.tokens[hash]
+	switch {
+	case !ok:
+		return Session{}, "", ErrInvalid
+	case t.family.revoked:
+		return t.family.Session, "", ErrRevoked
+	case t.used:
+		t.family.revoked = true
+		return t.family.Session, "", ErrReused
+	case !now.Before(t.expiresAt):
+		return t.family.Session, "", ErrExpired
+	}
+	t.used = true
+	s.retire(t.family, hash)
+	return t.family.Session, s.issue(t.family, now, ttl), nil
+}
+
+// Revoke ends the session of refresh. Revoking a revoked session succeeds.
+func (s *Store) Revoke(refresh string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[hashToken(refresh)]
+	if !ok {
+		return Session{}, ErrInvalid
+	}
+	t.family.revoked = true
+	return t.family.Session, nil
+}
+
+// RevokeSession ends the session named id, if it exists
+func (s *Store) RevokeSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.families[id]; ok {
+		f.revoked = true
+	}
+}
+
+// Revoked reports whether the session named id was revoked. Unknown sessions,
+// including expired ones, are not: the access tokens of an expired session
+// have expired before it.
+func (s *Store) Revoked(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.families[id]
+	return ok && f.revoked
+}
+
+// issue adds a refresh token to f valid for ttl from now
+func (s *Store) issue(f *family, now time.Time, ttl time.Duration) string {
+	refresh := randomString(32)
+	f.ExpiresAt = now.Add(ttl)
+	s.tokens[hashToken(refresh)] = &token{family: f, expiresAt: f.ExpiresAt}
+	return refresh
+}
+
+// retire records that the token of f hashed to hash was redeemed, forgetting
+// the oldest redeemed token beyond retainUsed
+func (s *Store) retire(f *family, hash string) {
+	f.used = append(f.used, hash)
+	if len(f.used) > retainUsed {
+		delete(s.tokens, f.used[0])
+		f.used = f.used[1:]
+	}
+}
+
+// sweep drops the sessions that have expired with all their tokens, at most
+// once per sweepEvery. The last retainUsed used tokens outlive their own
+// expiry until then, so their reuse is still detected.
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < s.sweepEvery {
+		return
+	}
+	s.sweptAt = now
+	for hash, t := range s.tokens {
+		if !now.Before(t.family.ExpiresAt) {
+			delete(s.tokens, hash)
+		}
+	}
+	for id, f := range s.families {
+		if !now.Before(f.ExpiresAt) {
+			delete(s.families, id)
+		}
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(refresh string) string {
+	sum := sha256.Sum256([]byte(refresh))
+	return hex.EncodeToString(sum[:])
+}
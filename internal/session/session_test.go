@@ -0,0 +1,159 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for Store.now
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestStore() (*Store, *clock) {
+	c := &clock{t: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	s := NewStore()
+	s.now = c.now
+	return s, c
+}
+
+func TestRotate(t *testing.T) {
+	s, _ := newTestStore()
+	sess, first := s.Start("user-1", time.Hour)
+
+	got, second, err := s.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if got.ID != sess.ID || got.UserID != "user-1" || second == first {
+		t.Errorf("Rotate = %+v, %q; want session %s and a new token", got, second, sess.ID)
+	}
+	if _, third, err := s.Rotate(second, time.Hour); err != nil || third == "" {
+		t.Fatalf("second Rotate: %q, %v", third, err)
+	}
+	if _, _, err := s.Rotate("not a token", time.Hour); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown token: expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestRotate_ReuseRevokesSession(t *testing.T) {
+	s, _ := newTestStore()
+	sess, first := s.Start("user-1", time.Hour)
+	other, otherToken := s.Start("user-1", time.Hour)
+	_, second, err := s.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An attacker replays the first token after the user rotated it
+	if _, _, err := s.Rotate(first, time.Hour); !errors.Is(err, ErrReused) {
+		t.Fatalf("replayed token: expected ErrReused, got %v", err)
+	}
+	if !s.Revoked(sess.ID) {
+		t.Error("session should be revoked after reuse")
+	}
+	if _, _, err := s.Rotate(second, time.Hour); !errors.Is(err, ErrRevoked) {
+		t.Errorf("latest token of the revoked session: expected ErrRevoked, got %v", err)
+	}
+	if _, err := s.Lookup(second); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Lookup in the revoked session: expected ErrRevoked, got %v", err)
+	}
+
+	// The user's other sessions are unaffected
+	if s.Revoked(other.ID) {
+		t.Error("other session should not be revoked")
+	}
+	if _, _, err := s.Rotate(otherToken, time.Hour); err != nil {
+		t.Errorf("other session: %v", err)
+	}
+}
+
+func TestRotate_PrunesUsedTokens(t *testing.T) {
+	s, _ := newTestStore()
+	sess, first := s.Start("user-1", time.Hour)
+	tokens := []string{first}
+	for range retainUsed + 2 {
+		_, next, err := s.Rotate(tokens[len(tokens)-1], time.Hour)
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		tokens = append(tokens, next)
+	}
+	if len(s.tokens) != retainUsed+1 {
+		t.Errorf("%d tokens kept, want the last %d used ones and the current one", len(s.tokens), retainUsed)
+	}
+
+	// A forgotten token is unknown; it cannot refresh, but no longer revokes
+	if _, _, err := s.Rotate(first, time.Hour); !errors.Is(err, ErrInvalid) {
+		t.Errorf("pruned token: expected ErrInvalid, got %v", err)
+	}
+	if s.Revoked(sess.ID) {
+		t.Error("replaying a pruned token should not revoke the session")
+	}
+	// A recently used one still does
+	if _, _, err := s.Rotate(tokens[len(tokens)-2], time.Hour); !errors.Is(err, ErrReused) {
+		t.Errorf("recently used token: expected ErrReused, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s, _ := newTestStore()
+	sess, first := s.Start("user-1", time.Hour)
+	_, second, err := s.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Logging out with an already rotated token still ends the session
+	if got, err := s.Revoke(first); err != nil || got.ID != sess.ID {
+		t.Fatalf("Revoke = %+v, %v", got, err)
+	}
+	if !s.Revoked(sess.ID) {
+		t.Error("session should be revoked")
+	}
+	if _, _, err := s.Rotate(second, time.Hour); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected ErrRevoked, got %v", err)
+	}
+	if _, err := s.Revoke(second); err != nil {
+		t.Errorf("revoking twice: %v", err)
+	}
+	if _, err := s.Revoke("not a token"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown token: expected ErrInvalid, got %v", err)
+	}
+	if s.Revoked("no such session") {
+		t.Error("unknown sessions should not be reported revoked")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s, c := newTestStore()
+	sess, first := s.Start("user-1", time.Hour)
+
+	c.t = c.t.Add(59 * time.Minute)
+	got, second, err := s.Rotate(first, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate before expiry: %v", err)
+	}
+	if want := c.t.Add(time.Hour); !got.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, want)
+	}
+
+	c.t = c.t.Add(time.Hour)
+	if _, err := s.Lookup(second); !errors.Is(err, ErrExpired) {
+		t.Errorf("Lookup after expiry: expected ErrExpired, got %v", err)
+	}
+	if _, _, err := s.Rotate(second, time.Hour); !errors.Is(err, ErrExpired) {
+		t.Errorf("Rotate after expiry: expected ErrExpired, got %v", err)
+	}
+
+	// Starting a session sweeps expired ones away
+	c.t = c.t.Add(sweepInterval)
+	s.Start("user-2", time.Hour)
+	if _, ok := s.families[sess.ID]; ok {
+		t.Error("expired session should have been swept")
+	}
+	if len(s.tokens) != 1 {
+		t.Errorf("%d tokens left after the sweep, want 1", len(s.tokens))
+	}
+}
@@ -0,0 +1,80 @@
+// Package tracing configures OpenTelemetry tracing for the services. Spans
+// are created by the otelgrpc stats handlers on every server and client, and
+// by the User service around storage operations; trace context crosses
+// process boundaries in W3C traceparent headers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+)
+
+// Values for config.Config.TracingExporter
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Setup installs the tracer provider selected by cfg.TracingExporter, naming
+// the process service in every span. The returned function flushes pending
+// spans and stops the exporter. With ExporterNone spans are never recorded,
+// but trace context is still propagated.
+func Setup(ctx context.Context, cfg *config.Config, service string) (shutdown func(context.Context) error, err error) {
+	if cfg.TracingExporter != ExporterOTLP {
+		installPropagator()
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(cfg.TracingEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	Install(tp)
+	return tp.Shutdown, nil
+}
+
+// Install makes tp the global tracer provider, and W3C trace context and
+// baggage the global propagators. Servers and clients created afterwards use
+// them.
+func Install(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	installPropagator()
+}
+
+func installPropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// ServerHandler returns the stats handler that traces a server's calls.
+// Health checks are left out; probes would otherwise dominate the traces.
+func ServerHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))
+}
+
+// ClientHandler is ServerHandler for client connections
+func ClientHandler() stats.Handler {
+	return otelgrpc.NewClientHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))
+}
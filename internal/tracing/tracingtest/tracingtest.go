@@ -0,0 +1,16 @@
+// Package tracingtest records spans in memory for tests to assert against
+package tracingtest
+
+import (
+	"github.com/mr1hm/grpc-demo/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewInMemory installs a tracer provider that records every span in the
+// returned exporter as soon as it ends
+func NewInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/mr1hm/grpc-demo/internal/certs"
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeerAuthorization(t *testing.T) {
+	dir := t.TempDir()
+	ca, files, err := certs.WriteDev(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Client certificates beyond the gateway's, from the same CA
+	clients := map[string]certs.DevCert{
+		"reporting": {CommonName: "reporting", Client: true},
+		"intruder":  {CommonName: "gateway", URIs: []*url.URL{certs.SPIFFEID("intruder")}, Client: true},
+	}
+	for name, spec := range clients {
+		if err := certs.WriteIssued(ca, spec, filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.New("127.0.0.1:0", ":0")
+	cfg.SetOutput(io.Discard)
+	files.Configure(cfg)
+	cfg.UserPeerAllowlist = "*=spiffe://grpc-demo.local/gateway,ListUsers=spiffe://grpc-demo.local/gateway|reporting"
+	svc := NewServiceWithStore(cfg, NewMemoryStore())
+	t.Cleanup(func() { svc.Close() })
+	server, err := svc.Start(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(cert, key string) *grpc.ClientConn {
+		t.Helper()
+		src, err := certs.NewSource(cert, key, files.CA, svc.log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := grpc.NewClient(server.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientConfig(src))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	conns := map[string]*grpc.ClientConn{
+		"gateway":   dial(files.GatewayClientCert, files.GatewayClientKey),
+		"reporting": dial(filepath.Join(dir, "reporting.pem"), filepath.Join(dir, "reporting-key.pem")),
+		"intruder":  dial(filepath.Join(dir, "intruder.pem"), filepath.Join(dir, "intruder-key.pem")),
+	}
+
+	createUser := func(conn *grpc.ClientConn) error {
+		_, err := userpb.NewUserServiceClient(conn).CreateUser(context.Background(), &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com"})
+		return err
+	}
+	listUsers := func(conn *grpc.ClientConn) error {
+		_, err := userpb.NewUserServiceClient(conn).ListUsers(context.Background(), &userpb.ListUsersRequest{})
+		return err
+	}
+	checkHealth := func(conn *grpc.ClientConn) error {
+		_, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		caller   string
+		call     func(*grpc.ClientConn) error
+		wantCode codes.Code
+	}{
+		{name: "gateway by SPIFFE ID", caller: "gateway", call: createUser},
+		{name: "gateway on a method with its own rule", caller: "gateway", call: listUsers},
+		{name: "common name on its method", caller: "reporting", call: listUsers},
+		{name: "common name on another method", caller: "reporting", call: createUser, wantCode: codes.PermissionDenied},
+		// Its common name says gateway, but its SPIFFE ID decides
+		{name: "unknown SPIFFE ID", caller: "intruder", call: createUser, wantCode: codes.PermissionDenied},
+		{name: "health is not restricted", caller: "intruder", call: checkHealth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(conns[tt.caller]); status.Code(err) != tt.wantCode {
+				t.Errorf("error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("reloaded allowlist", func(t *testing.T) {
+		next := *cfg
+		next.UserPeerAllowlist = "*=reporting"
+		svc.applyConfig(&next)
+		t.Cleanup(func() { svc.applyConfig(cfg) })
+		if err := createUser(conns["gateway"]); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("gateway error = %v, want PermissionDenied", err)
+		}
+	})
+}
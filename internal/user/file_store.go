@@ -0,0 +1,498 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+
+	// walHeaderSize is the length prefix plus the CRC of each log record
+	walHeaderSize = 8
+	// maxWALRecord guards against allocating huge buffers for a garbage length prefix
+	maxWALRecord = 1 << 20
+
+	defaultSnapshotEvery = 1000
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptLogError reports a write-ahead log that could not be fully replayed.
+// Everything before Offset was applied; everything from Offset on was not.
+type CorruptLogError struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptLogError) Error() string {
+	return fmt.Sprintf("corrupt write-ahead log %s at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+// FileStoreOptions tunes an embedded FileStore
+type FileStoreOptions struct {
+	// SnapshotEvery compacts the log into a snapshot after this many appended records
+	SnapshotEvery int
+	// TruncateCorruptTail drops a torn or corrupt log tail instead of failing to open.
+	// The dropped region is reported by FileStore.Recovered.
+	TruncateCorruptTail bool
+}
+
+// FileStore is an embedded, file-backed store. Every mutation is appended to a
+// write-ahead log and fsynced before it is acknowledged; the log is periodically
+// compacted into a snapshot. Both are replayed on open.
+type FileStore struct {
+	mu         sync.RWMutex
+	dir        string
+	opts       FileStoreOptions
+	wal        *os.File
+	users      map[string]*User
+	emails     emailIndex
+	statuses   statusCounts
+	nextID     int
+	lsn        uint64
+	walCount   int
+	compactErr error
+	recovered  *CorruptLogError
+}
+
+// snapshot is the on-disk compacted state. LSN is the last log record it includes.
+type snapshot struct {
+	LSN    uint64  `json:"lsn"`
+	NextID int     `json:"next_id"`
+	Users  []*User `json:"users"`
+}
+
+// walRecord is a single logged mutation
+type walRecord struct {
+	LSN    uint64 `json:"lsn"`
+	Op     string `json:"op"`
+	User   *User  `json:"user"`
+	NextID int    `json:"next_id"`
+}
+
+const (
+	opCreate = "create"
+	opUpdate = "update"
+)
+
+// OpenFileStore opens (or initialises) the store in dir, replaying the snapshot
+// and write-ahead log found there.
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("file store requires a path")
+	}
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = defaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create user store directory: %w", err)
+	}
+
+	f := &FileStore{
+		dir:      dir,
+		opts:     opts,
+		users:    make(map[string]*User),
+		emails:   make(emailIndex),
+		statuses: make(statusCounts),
+		nextID:   1,
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	// A log created just now must still be there after a crash
+	if err := syncDir(dir); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	end, err := f.replay(wal)
+	if err != nil {
+		var corrupt *CorruptLogError
+		if !errors.As(err, &corrupt) || !opts.TruncateCorruptTail {
+			wal.Close()
+			return nil, err
+		}
+		if err := wal.Truncate(end); err != nil {
+			wal.Close()
+			return nil, fmt.Errorf("truncate write-ahead log: %w", err)
+		}
+		f.recovered = corrupt
+	}
+	if _, err := wal.Seek(end, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("seek write-ahead log: %w", err)
+	}
+	f.wal = wal
+	return f, nil
+}
+
+// Recovered returns the corruption that was truncated on open, if any
+func (f *FileStore) Recovered() *CorruptLogError {
+	return f.recovered
+}
+
+// Get returns a copy of the user with the given ID
+func (f *FileStore) Get(ctx context.Context, id string) (*User, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	u, exists := f.users[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+// GetByEmail returns a copy of the user holding email
+func (f *FileStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	id, exists := f.emails.lookup(email)
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *f.users[id]
+	return &cp, nil
+}
+
+// Create assigns the next sequential ID to u and logs it before returning
+func (f *FileStore) Create(ctx context.Context, u *User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.emails.check("", u.Email); err != nil {
+		return err
+	}
+
+	cp := *u
+	cp.ID = fmt.Sprintf("user-%d", f.nextID)
+	rec := &walRecord{
+		LSN:    f.lsn + 1,
+		Op:     opCreate,
+		User:   &cp,
+		NextID: f.nextID + 1,
+	}
+	if err := f.append(rec); err != nil {
+		return err
+	}
+	f.apply(rec)
+
+	u.ID = cp.ID
+
+	// The record is durable at this point, so a failed compaction only means
+	// the log keeps growing; it is retried on the next write and on Close.
+	if f.walCount >= f.opts.SnapshotEvery {
+		f.compactErr = f.compact()
+	}
+	return nil
+}
+
+// Update applies fn to a copy of the stored user and logs the result if fn succeeds
+func (f *FileStore) Update(ctx context.Context, id string, fn func(*User) error) (*User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, exists := f.users[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	if err := fn(&cp); err != nil {
+		return nil, err
+	}
+	cp.ID = id
+	if err := f.emails.check(id, cp.Email); err != nil {
+		return nil, err
+	}
+
+	rec := &walRecord{
+		LSN:    f.lsn + 1,
+		Op:     opUpdate,
+		User:   &cp,
+		NextID: f.nextID,
+	}
+	if err := f.append(rec); err != nil {
+		return nil, err
+	}
+	f.apply(rec)
+
+	if f.walCount >= f.opts.SnapshotEvery {
+		f.compactErr = f.compact()
+	}
+	out := cp
+	return &out, nil
+}
+
+// List returns copies of the users selected by q
+func (f *FileStore) List(ctx context.Context, q ListQuery) ([]*User, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	users := make([]*User, 0, len(f.users))
+	for _, u := range f.users {
+		cp := *u
+		users = append(users, &cp)
+	}
+	return q.apply(users), nil
+}
+
+// CountByStatus returns the tally kept as users are stored
+func (f *FileStore) CountByStatus(ctx context.Context) (map[Status]int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return maps.Clone(f.statuses), nil
+}
+
+// CompactErr returns the error from the most recent background compaction, if it failed
+func (f *FileStore) CompactErr() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.compactErr
+}
+
+// Close compacts the log into a snapshot and closes it
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return nil
+	}
+	err := f.compact()
+	if cerr := f.wal.Close(); err == nil {
+		err = cerr
+	}
+	f.wal = nil
+	return err
+}
+
+// apply mutates the in-memory state. Callers must hold f.mu.
+func (f *FileStore) apply(rec *walRecord) {
+	switch rec.Op {
+	case opCreate, opUpdate:
+		f.put(rec.User)
+	}
+	if rec.NextID > f.nextID {
+		f.nextID = rec.NextID
+	}
+	f.lsn = rec.LSN
+}
+
+// put stores u and keeps the email index and status counts in step. Callers
+// must hold f.mu.
+func (f *FileStore) put(u *User) {
+	var old string
+	prev, exists := f.users[u.ID]
+	if exists {
+		old = prev.Email
+	}
+	f.users[u.ID] = u
+	f.emails.move(u.ID, old, u.Email)
+	f.statuses.move(prev, u)
+}
+
+// append writes and fsyncs a single framed record. Callers must hold f.mu.
+func (f *FileStore) append(rec *walRecord) error {
+	if f.wal == nil {
+		return errors.New("user store is closed")
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode log record: %w", err)
+	}
+
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[walHeaderSize:], payload)
+
+	start, err := f.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("seek write-ahead log: %w", err)
+	}
+	if _, err := f.wal.Write(buf); err != nil {
+		f.rewind(start)
+		return fmt.Errorf("append log record: %w", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		f.rewind(start)
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	f.walCount++
+	return nil
+}
+
+// rewind drops a partially written record so later appends are not stranded
+// behind it. Callers must hold f.mu.
+func (f *FileStore) rewind(offset int64) {
+	f.wal.Truncate(offset)
+	f.wal.Seek(offset, io.SeekStart)
+}
+
+// replay applies every intact record in wal and returns the offset just past
+// the last one. A torn or corrupt record yields a *CorruptLogError.
+func (f *FileStore) replay(wal *os.File) (int64, error) {
+	r := bufio.NewReader(wal)
+	var offset int64
+	header := make([]byte, walHeaderSize)
+
+	for {
+		n, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, f.corrupt(offset, fmt.Sprintf("truncated record header (%d of %d bytes)", n, walHeaderSize))
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size == 0 || size > maxWALRecord {
+			return offset, f.corrupt(offset, fmt.Sprintf("invalid record length %d", size))
+		}
+
+		payload := make([]byte, size)
+		if n, err := io.ReadFull(r, payload); err != nil {
+			return offset, f.corrupt(offset, fmt.Sprintf("truncated record (%d of %d bytes)", n, size))
+		}
+		if crc32.Checksum(payload, crcTable) != sum {
+			return offset, f.corrupt(offset, "checksum mismatch")
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return offset, f.corrupt(offset, fmt.Sprintf("undecodable record: %v", err))
+		}
+		// Records already folded into the snapshot are skipped so that a crash
+		// between writing the snapshot and truncating the log is harmless.
+		if rec.LSN > f.lsn {
+			f.apply(&rec)
+			f.walCount++
+		}
+		offset += int64(walHeaderSize) + int64(size)
+	}
+}
+
+func (f *FileStore) corrupt(offset int64, reason string) error {
+	return &CorruptLogError{
+		Path:   filepath.Join(f.dir, walFile),
+		Offset: offset,
+		Reason: reason,
+	}
+}
+
+func (f *FileStore) loadSnapshot() error {
+	raw, err := os.ReadFile(filepath.Join(f.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, u := range snap.Users {
+		f.put(u)
+	}
+	if snap.NextID > f.nextID {
+		f.nextID = snap.NextID
+	}
+	f.lsn = snap.LSN
+	return nil
+}
+
+// compact writes a snapshot of the current state and then empties the log.
+// Callers must hold f.mu.
+func (f *FileStore) compact() error {
+	if f.walCount == 0 {
+		return nil
+	}
+
+	snap := snapshot{LSN: f.lsn, NextID: f.nextID}
+	for _, u := range f.users {
+		snap.Users = append(snap.Users, u)
+	}
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(f.dir, snapshotFile), raw); err != nil {
+		return err
+	}
+
+	// The snapshot is durable by now; emptying the log any earlier could
+	// lose both copies of its records in a crash
+	if err := f.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate write-ahead log: %w", err)
+	}
+	if _, err := f.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek write-ahead log: %w", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	f.walCount = 0
+	return nil
+}
+
+// writeFileAtomic replaces path with data via a synced temp file and rename.
+// The directory is synced too, so the new file survives a crash once it returns.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes dir's entries to disk, making renames and file creations
+// in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("sync directory %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory %s: %w", dir, err)
+	}
+	return nil
+}
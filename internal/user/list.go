@@ -0,0 +1,131 @@
+package user
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort keys accepted by ListQuery.OrderBy
+const (
+	OrderByCreatedAt = "created_at"
+	OrderByName      = "name"
+)
+
+// ListCursor is the sort key of the last user on the previous page. Listing
+// resumes strictly after it, so rows inserted concurrently never shift a page.
+type ListCursor struct {
+	CreatedAt int64  `json:"c,omitempty"` // Unix nanoseconds, used with OrderByCreatedAt
+	Name      string `json:"n,omitempty"` // Used with OrderByName
+	Seq       int    `json:"s"`           // Tiebreaker: the numeric part of the user ID
+}
+
+// ListQuery selects and orders a page of users
+type ListQuery struct {
+	NamePrefix  string   // Case-insensitive
+	EmailPrefix string   // Case-insensitive
+	Statuses    []Status // Empty matches every status
+	OrderBy     string   // OrderByCreatedAt (default) or OrderByName
+	Descending  bool
+	After       *ListCursor
+	Limit       int // 0 means no limit
+}
+
+// fingerprint identifies the filters and ordering of q, excluding its position
+func (q ListQuery) fingerprint() string {
+	return fmt.Sprintf("%q|%q|%v|%s|%t", q.NamePrefix, q.EmailPrefix, q.Statuses, q.OrderBy, q.Descending)
+}
+
+// cursorFor returns the position of u in the ordering used by q
+func (q ListQuery) cursorFor(u *User) *ListCursor {
+	c := &ListCursor{Seq: idSeq(u.ID)}
+	if q.OrderBy == OrderByName {
+		c.Name = u.Name
+	} else {
+		c.CreatedAt = unixNano(u.CreatedAt)
+	}
+	return c
+}
+
+// compare orders two cursors according to q, ignoring direction
+func (q ListQuery) compare(a, b *ListCursor) int {
+	if q.OrderBy == OrderByName {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+	} else if a.CreatedAt != b.CreatedAt {
+		if a.CreatedAt < b.CreatedAt {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case a.Seq < b.Seq:
+		return -1
+	case a.Seq > b.Seq:
+		return 1
+	}
+	return 0
+}
+
+// matches reports whether u passes q's filters
+func (q ListQuery) matches(u *User) bool {
+	if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, u.currentStatus()) {
+		return false
+	}
+	if q.NamePrefix != "" && !hasPrefixFold(u.Name, q.NamePrefix) {
+		return false
+	}
+	if q.EmailPrefix != "" && !hasPrefixFold(u.Email, q.EmailPrefix) {
+		return false
+	}
+	return true
+}
+
+// apply filters, orders and pages users in memory for stores without a query engine
+func (q ListQuery) apply(users []*User) []*User {
+	out := users[:0]
+	for _, u := range users {
+		if q.matches(u) {
+			out = append(out, u)
+		}
+	}
+
+	less := func(i, j int) bool {
+		c := q.compare(q.cursorFor(out[i]), q.cursorFor(out[j]))
+		if q.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(out, less)
+
+	if q.After != nil {
+		start := sort.Search(len(out), func(i int) bool {
+			c := q.compare(q.cursorFor(out[i]), q.After)
+			if q.Descending {
+				return c < 0
+			}
+			return c > 0
+		})
+		out = out[start:]
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// unixNano maps the zero time to 0 rather than a large negative number
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
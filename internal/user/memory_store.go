@@ -0,0 +1,122 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// MemoryStore keeps users in a map. Data is lost when the process exits.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	users    map[string]*User
+	emails   emailIndex
+	statuses statusCounts
+	nextID   int
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]*User),
+		emails:   make(emailIndex),
+		statuses: make(statusCounts),
+		nextID:   1,
+	}
+}
+
+// Get returns a copy of the user with the given ID
+func (m *MemoryStore) Get(ctx context.Context, id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, exists := m.users[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+// GetByEmail returns a copy of the user holding email
+func (m *MemoryStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, exists := m.emails.lookup(email)
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *m.users[id]
+	return &cp, nil
+}
+
+// Create assigns the next sequential ID to u and stores a copy of it
+func (m *MemoryStore) Create(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.emails.check("", u.Email); err != nil {
+		return err
+	}
+
+	u.ID = fmt.Sprintf("user-%d", m.nextID)
+	m.nextID++
+
+	cp := *u
+	m.users[u.ID] = &cp
+	m.emails.move(u.ID, "", u.Email)
+	m.statuses.move(nil, &cp)
+	return nil
+}
+
+// Update applies fn to a copy of the stored user and saves it if fn succeeds
+func (m *MemoryStore) Update(ctx context.Context, id string, fn func(*User) error) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, exists := m.users[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	if err := fn(&cp); err != nil {
+		return nil, err
+	}
+	cp.ID = id
+	if err := m.emails.check(id, cp.Email); err != nil {
+		return nil, err
+	}
+
+	m.users[id] = &cp
+	m.emails.move(id, u.Email, cp.Email)
+	m.statuses.move(u, &cp)
+	out := cp
+	return &out, nil
+}
+
+// List returns copies of the users selected by q
+func (m *MemoryStore) List(ctx context.Context, q ListQuery) ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]*User, 0, len(m.users))
+	for _, u := range m.users {
+		cp := *u
+		users = append(users, &cp)
+	}
+	return q.apply(users), nil
+}
+
+// CountByStatus returns the tally kept as users are stored
+func (m *MemoryStore) CountByStatus(ctx context.Context) (map[Status]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.statuses), nil
+}
+
+// Close is a no-op for the in-memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
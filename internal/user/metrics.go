@@ -0,0 +1,63 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countTimeout bounds the store query behind the user_accounts gauge
+const countTimeout = 5 * time.Second
+
+// serviceMetrics is the Prometheus collector of a Service's domain metrics
+type serviceMetrics struct {
+	store UserStore
+
+	// registrations counts users created by this process. Registrations per
+	// minute is rate(user_registrations_total[1m]) * 60.
+	registrations prometheus.Counter
+	verifications prometheus.Counter
+	accounts      *prometheus.Desc
+}
+
+func newServiceMetrics(store UserStore) *serviceMetrics {
+	return &serviceMetrics{
+		store: store,
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "user_registrations_total",
+			Help: "Users registered through CreateUser.",
+		}),
+		verifications: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "user_email_verifications_total",
+			Help: "Email addresses verified through VerifyEmail.",
+		}),
+		accounts: prometheus.NewDesc("user_accounts",
+			"Users in the store by status, counted at scrape time.",
+			[]string{"status"}, nil),
+	}
+}
+
+func (m *serviceMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.registrations.Describe(ch)
+	m.verifications.Describe(ch)
+	ch <- m.accounts
+}
+
+// Collect counts the store's users on every scrape, so replicas sharing a
+// store all report its true totals
+func (m *serviceMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.registrations.Collect(ch)
+	m.verifications.Collect(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	counts, err := m.store.CountByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(m.accounts, err)
+		return
+	}
+	for _, status := range []Status{StatusPendingVerification, StatusActive, StatusSuspended, StatusDeleted} {
+		ch <- prometheus.MustNewConstMetric(m.accounts, prometheus.GaugeValue, float64(counts[status]), string(status))
+	}
+}
@@ -0,0 +1,10 @@
+-- seq is allocated by the database so that every replica sharing it hands out
+-- distinct IDs; id is the public "user-<seq>" form.
+CREATE TABLE users (
+    seq   INTEGER PRIMARY KEY AUTOINCREMENT,
+    id    TEXT    NOT NULL UNIQUE,
+    name  TEXT    NOT NULL,
+    email TEXT    NOT NULL
+);
+
+CREATE UNIQUE INDEX users_email_idx ON users (email);
@@ -0,0 +1,6 @@
+-- Unix nanoseconds, so that keyset pagination compares integers rather than
+-- driver-formatted timestamp strings. Rows from before this migration sort first.
+ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX users_created_at_idx ON users (created_at, seq);
+CREATE INDEX users_name_idx ON users (name, seq);
@@ -0,0 +1,8 @@
+-- Uniqueness moves from the raw address to its case-folded, normalized key
+-- (validation.EmailKey). lower() only folds ASCII; the application writes the
+-- full key for every row it touches from here on.
+ALTER TABLE users ADD COLUMN email_key TEXT;
+UPDATE users SET email_key = lower(trim(email));
+
+DROP INDEX users_email_idx;
+CREATE UNIQUE INDEX users_email_key_idx ON users (email_key);
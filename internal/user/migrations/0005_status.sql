@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+UPDATE users SET status = 'deleted' WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX users_status_idx ON users (status);
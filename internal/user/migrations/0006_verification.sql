@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN verification_token_hash TEXT;
+ALTER TABLE users ADD COLUMN verification_expires_at INTEGER;
@@ -0,0 +1,2 @@
+-- argon2id hash in PHC string form; NULL for users who never set a password
+ALTER TABLE users ADD COLUMN password_hash TEXT;
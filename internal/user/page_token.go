@@ -0,0 +1,71 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken is the signed payload behind ListUsers' opaque next_page_token
+type pageToken struct {
+	// Query fingerprints the filters and ordering the token was issued for,
+	// so it cannot be replayed against a different listing.
+	Query  string      `json:"q"`
+	Cursor *ListCursor `json:"k"`
+}
+
+// pageTokenSigner HMAC-signs page tokens so clients cannot forge cursors
+type pageTokenSigner struct {
+	key []byte
+}
+
+// newPageTokenSigner uses secret as the HMAC key, or a random key when it is
+// empty. Replicas behind one load balancer must share a secret.
+func newPageTokenSigner(secret string) *pageTokenSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &pageTokenSigner{key: key}
+}
+
+func (p *pageTokenSigner) encode(tok pageToken) string {
+	payload, _ := json.Marshal(tok)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(p.sign(payload))
+}
+
+func (p *pageTokenSigner) decode(s string) (pageToken, error) {
+	var tok pageToken
+	enc := base64.RawURLEncoding
+
+	body, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return tok, errInvalidPageToken
+	}
+	payload, err := enc.DecodeString(body)
+	if err != nil {
+		return tok, errInvalidPageToken
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, p.sign(payload)) {
+		return tok, errInvalidPageToken
+	}
+	if err := json.Unmarshal(payload, &tok); err != nil || tok.Cursor == nil {
+		return tok, errInvalidPageToken
+	}
+	return tok, nil
+}
+
+func (p *pageTokenSigner) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, p.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters for new password hashes: the RFC 9106 second
+// recommended option. Hashes keep the parameters they were made with, so
+// raising these does not invalidate stored passwords.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// errInvalidCredentials reports an unknown email or a wrong password alike,
+// so a failed login reveals nothing about which users exist
+var errInvalidCredentials = errors.New("invalid email or password")
+
+// hashSlots bounds the argon2id hashes computed at once. Each holds
+// argonMemory and a CPU for its duration, so a burst of logins, even for
+// unknown emails, could otherwise exhaust both.
+type hashSlots chan struct{}
+
+// newHashSlots allows n hashes at once, or one per CPU if n is 0
+func newHashSlots(n int) hashSlots {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return make(hashSlots, n)
+}
+
+// acquire takes a slot without waiting, reporting whether one was free.
+// A caller that gets one must release it.
+func (h hashSlots) acquire() bool {
+	select {
+	case h <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h hashSlots) release() {
+	<-h
+}
+
+// hashPassword returns the argon2id hash of password in the PHC string
+// format: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func hashPassword(password string) string {
+	salt := make([]byte, argonSaltLen)
+	rand.Read(salt)
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// checkPassword reports whether password matches hash. A malformed hash
+// matches nothing.
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked against when no user has the email being
+// logged in with, so that the answer takes as long as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() string { return hashPassword("") })
+
+// authenticate checks password against u's and returns errInvalidCredentials
+// if it does not match or u has none
+func (u *User) authenticate(password string) error {
+	if u.PasswordHash == "" {
+		checkPassword(dummyPasswordHash(), password)
+		return errInvalidCredentials
+	}
+	if !checkPassword(u.PasswordHash, password) {
+		return errInvalidCredentials
+	}
+	return nil
+}
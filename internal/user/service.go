@@ -2,91 +2,702 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"sync"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/mr1hm/grpc-demo/internal/authz"
+	"github.com/mr1hm/grpc-demo/internal/certs"
 	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/logging"
+	"github.com/mr1hm/grpc-demo/internal/mail"
+	"github.com/mr1hm/grpc-demo/internal/metrics"
+	"github.com/mr1hm/grpc-demo/internal/server"
+	"github.com/mr1hm/grpc-demo/internal/tracing"
+	"github.com/mr1hm/grpc-demo/internal/validation"
 	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Service implements the UserService gRPC server
 type Service struct {
 	userpb.UnimplementedUserServiceServer
-	cfg    *config.Config
-	mu     sync.RWMutex
-	users  map[string]*userpb.GetUserResponse
-	nextID int
+	cfg        *config.Config
+	log        *logrus.Entry
+	store      UserStore
+	pageTokens *pageTokenSigner
+	mailer     mail.Mailer
+	metrics    *serviceMetrics
+	health     *health.Server
+	creds      credentials.TransportCredentials
+	hashing    hashSlots
+	now        func() time.Time
+
+	// live holds the reloadable settings currently in effect, and peers the
+	// allowlist parsed from them
+	live        atomic.Pointer[config.Config]
+	peers       atomic.Pointer[authz.Allowlist]
+	unsubscribe func()
 }
 
-// NewService creates a new User service instance
+// NewService creates a new User service backed by the store selected in cfg
 func NewService(cfg *config.Config) *Service {
-	return &Service{
-		cfg:    cfg,
-		users:  make(map[string]*userpb.GetUserResponse),
-		nextID: 1,
+	store, err := NewStore(cfg)
+	if err != nil {
+		cfg.Fatalf("Failed to open user store: %v", err)
 	}
+
+	return NewServiceWithStore(cfg, store)
 }
 
-// Start creates a listener, registers the service, and starts serving in a goroutine.
-// Returns the server for graceful shutdown.
-func (s *Service) Start() *grpc.Server {
-	lis, err := net.Listen("tcp", s.cfg.UserServicePort)
-	if err != nil {
-		s.cfg.Fatalf("User service failed to listen: %v", err)
+// NewServiceWithStore creates a User service with a provided store (for testing)
+func NewServiceWithStore(cfg *config.Config, store UserStore) *Service {
+	return NewServiceWithMailer(cfg, store, mail.NewFileMailer(cfg.Logger, cfg.MailOutboxPath))
+}
+
+// NewServiceWithMailer creates a User service with a provided store and mailer (for testing)
+func NewServiceWithMailer(cfg *config.Config, store UserStore, mailer mail.Mailer) *Service {
+	s := &Service{
+		cfg:        cfg,
+		log:        cfg.PackageLogger("user"),
+		store:      newTracedStore(store),
+		pageTokens: newPageTokenSigner(cfg.PageTokenSecret),
+		mailer:     mailer,
+		metrics:    newServiceMetrics(store),
+		health:     health.NewServer(),
+		hashing:    newHashSlots(cfg.PasswordHashConcurrency),
+		now:        time.Now,
 	}
+	s.health.SetServingStatus(ServiceName, healthpb.HealthCheckResponse_SERVING)
+	if cfg.UserTLSCert != "" {
+		src, err := certs.NewSource(cfg.UserTLSCert, cfg.UserTLSKey, cfg.TLSCA, s.log)
+		if err != nil {
+			cfg.Fatalf("Failed to load User service TLS files: %v", err)
+		}
+		s.creds = credentials.NewTLS(certs.ServerConfig(src))
+	}
+	s.live.Store(cfg.Current())
+	s.storePeers(cfg)
+	s.unsubscribe = cfg.Subscribe(s.applyConfig)
+	return s
+}
 
-	server := grpc.NewServer()
-	userpb.RegisterUserServiceServer(server, s)
-	reflection.Register(server)
+// applyConfig switches to a reloaded configuration
+func (s *Service) applyConfig(next *config.Config) {
+	s.live.Store(next)
+	s.storePeers(next)
+	s.log.Debug("Applied reloaded configuration")
+}
 
-	go func() {
-		s.cfg.Infof("[User Service] Starting on %s", s.cfg.UserServicePort)
-		if err := server.Serve(lis); err != nil {
-			s.cfg.Fatalf("User service error: %v", err)
+// storePeers parses the peer allowlist of cfg, which Validate has already
+// checked. An empty allowlist is stored as nil, allowing every caller.
+func (s *Service) storePeers(cfg *config.Config) {
+	var peers authz.Allowlist
+	if cfg.UserPeerAllowlist != "" {
+		var err error
+		if peers, err = authz.ParseAllowlist(cfg.UserPeerAllowlist); err != nil {
+			s.log.WithError(err).Error("Ignoring invalid user_peer_allowlist; denying every caller")
+			peers = authz.Allowlist{}
 		}
-	}()
+	}
+	s.peers.Store(&peers)
+}
+
+// allowlist returns the peer allowlist in effect
+func (s *Service) allowlist() authz.Allowlist {
+	return *s.peers.Load()
+}
+
+// logger returns the logger of the request in ctx
+func (s *Service) logger(ctx context.Context) *logrus.Entry {
+	return logging.FromContext(ctx, s.log)
+}
+
+// ServiceName is the name the service's health is reported under
+var ServiceName = userpb.UserService_ServiceDesc.ServiceName
+
+// Health returns the health service of servers created by NewServer. It
+// reports the overall status under "" and the UserService's under ServiceName.
+func (s *Service) Health() *health.Server {
+	return s.health
+}
 
+// Collector returns the service's domain metrics: registrations, email
+// verifications and users by status
+func (s *Service) Collector() prometheus.Collector {
+	return s.metrics
+}
+
+// Close stops following config reloads and closes the underlying store
+func (s *Service) Close() error {
+	s.unsubscribe()
+	return s.store.Close()
+}
+
+// NewServer returns a gRPC server with the service and its health service
+// registered and its tracing and logging instrumentation installed, for
+// callers that serve it themselves. It serves mutual TLS when user_tls_cert
+// is set, and then admits only the callers user_peer_allowlist allows.
+func (s *Service) NewServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(tracing.ServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			metrics.Server.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(s.log),
+			authz.UnaryServerInterceptor(ServiceName, s.allowlist),
+		),
+		grpc.ChainStreamInterceptor(
+			metrics.Server.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(s.log),
+			authz.StreamServerInterceptor(ServiceName, s.allowlist),
+		),
+	}
+	if s.creds != nil {
+		opts = append(opts, grpc.Creds(s.creds))
+	}
+	server := grpc.NewServer(opts...)
+	userpb.RegisterUserServiceServer(server, s)
+	healthpb.RegisterHealthServer(server, s.health)
+	reflection.Register(server)
+	metrics.Server.InitializeMetrics(server)
 	return server
 }
 
-// GetUser retrieves a user by ID
-func (s *Service) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
-	s.cfg.Infof("[User] GetUser called with ID: %s", req.UserId)
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Start serves the service in a goroutine on lis, or on a new listener on
+// UserServicePort when lis is nil, until ctx is done or the returned server is
+// stopped. Wait on the server to learn why it stopped serving.
+func (s *Service) Start(ctx context.Context, lis net.Listener) (*server.Server, error) {
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", s.cfg.UserServicePort); err != nil {
+			return nil, fmt.Errorf("listen on %s: %w", s.cfg.UserServicePort, err)
+		}
+	}
+	s.log.Infof("Starting on %s", lis.Addr())
+	return server.Serve(ctx, s.NewServer(), lis), nil
+}
 
-	user, exists := s.users[req.UserId]
-	if !exists {
-		return nil, status.Errorf(codes.NotFound, "user %s not found", req.UserId)
+// GetUser retrieves a user by ID. Soft-deleted users are reported as not found.
+func (s *Service) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Getting user")
+	user, err := s.store.Get(ctx, req.UserId)
+	if err == nil && user.Deleted() {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return nil, storeError(err, req.UserId)
 	}
 
-	return user, nil
+	return &userpb.GetUserResponse{
+		UserId: user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Status: user.currentStatus().Proto(),
+	}, nil
 }
 
-// CreateUser creates a new user
+// CreateUser creates a new user pending email verification and emails them a
+// verification token. A password, if given, is stored as an argon2id hash.
 func (s *Service) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
-	s.cfg.Infof("[User] CreateUser called with Name: %s - Email: %s", req.Name, req.Email)
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.logger(ctx).WithField("request", logging.Proto(req)).Debug("Creating user")
+	var v validation.Violations
+	name := validation.Name(&v, "name", req.Name)
+	email := validation.Email(&v, "email", req.Email)
+	if req.Password != "" {
+		validation.Password(&v, "password", req.Password)
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
 
-	userID := fmt.Sprintf("user-%d", s.nextID)
-	s.nextID++
+	now := s.now().UTC()
+	secret, verification := newVerificationSecret(now, s.live.Load().VerificationTokenTTL)
+	user := &User{
+		Name:         name,
+		Email:        email,
+		Status:       StatusPendingVerification,
+		CreatedAt:    now,
+		Verification: verification,
+	}
+	if req.Password != "" {
+		if !s.hashing.acquire() {
+			return nil, errHashingBusy
+		}
+		user.PasswordHash = hashPassword(req.Password)
+		s.hashing.release()
+	}
+	if err := s.store.Create(ctx, user); err != nil {
+		return nil, storeError(err, "")
+	}
+	s.metrics.registrations.Inc()
 
-	user := &userpb.GetUserResponse{
-		UserId: userID,
-		Name:   req.Name,
-		Email:  req.Email,
+	// The user exists either way, so a failed send is logged rather than
+	// failing the registration; ResendVerification issues a new token.
+	if err := s.sendVerification(ctx, user, secret); err != nil {
+		s.logger(ctx).WithField("user_id", user.ID).WithError(err).Error("Failed to send verification email")
 	}
-	s.users[userID] = user
 
 	return &userpb.CreateUserResponse{
-		UserId: userID,
-		Name:   req.Name,
-		Email:  req.Email,
+		UserId: user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Status: user.currentStatus().Proto(),
 	}, nil
 }
+
+// sendVerification emails user the token that redeems secret
+func (s *Service) sendVerification(ctx context.Context, user *User, secret string) error {
+	token := verificationToken(user.ID, secret)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nUse this token to verify your email address:\n\n%s\n\nIt expires at %s.\n",
+			user.Name, token, user.Verification.ExpiresAt.Format(time.RFC1123)),
+	})
+}
+
+// VerifyEmail redeems a verification token, activating the user it was issued to.
+// Each token works once and only until it expires.
+func (s *Service) VerifyEmail(ctx context.Context, req *userpb.VerifyEmailRequest) (*userpb.VerifyEmailResponse, error) {
+	s.logger(ctx).Debug("Verifying email")
+	userID, secret, err := parseVerificationToken(req.Token)
+	if err != nil {
+		return nil, verificationError(err)
+	}
+
+	now := s.now()
+	user, err := s.store.Update(ctx, userID, func(u *User) error {
+		return u.redeemVerification(secret, now)
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil, verificationError(errInvalidVerificationToken)
+	case errors.Is(err, errInvalidVerificationToken), errors.Is(err, errExpiredVerificationToken):
+		return nil, verificationError(err)
+	case err != nil:
+		return nil, storeError(err, userID)
+	}
+
+	s.metrics.verifications.Inc()
+	s.logger(ctx).WithField("user_id", user.ID).Info("Verified email")
+	return &userpb.VerifyEmailResponse{
+		UserId: user.ID,
+		Status: user.currentStatus().Proto(),
+	}, nil
+}
+
+// ResendVerification replaces the verification token of an unverified user and
+// emails the new one, recovering a token that was lost, expired or never sent.
+// Unknown, deleted and already verified addresses get the same empty response.
+func (s *Service) ResendVerification(ctx context.Context, req *userpb.ResendVerificationRequest) (*userpb.ResendVerificationResponse, error) {
+	s.logger(ctx).Debug("Resending verification email")
+	var v validation.Violations
+	email := validation.Email(&v, "email", req.Email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only pending users get a new token; everyone else is answered the same
+	secret, verification := newVerificationSecret(s.now().UTC(), s.live.Load().VerificationTokenTTL)
+	user, err := s.store.GetByEmail(ctx, email)
+	if err == nil {
+		user, err = s.store.Update(ctx, user.ID, func(u *User) error {
+			return u.renewVerification(verification)
+		})
+	}
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, errNotPendingVerification):
+		return &userpb.ResendVerificationResponse{}, nil
+	case err != nil:
+		return nil, storeError(err, "")
+	}
+
+	if err := s.sendVerification(ctx, user, secret); err != nil {
+		s.logger(ctx).WithField("user_id", user.ID).WithError(err).Error("Failed to send verification email")
+		return nil, status.Error(codes.Unavailable, "failed to send verification email")
+	}
+	s.logger(ctx).WithField("user_id", user.ID).Info("Resent verification email")
+	return &userpb.ResendVerificationResponse{}, nil
+}
+
+// AuthenticateUser checks an email and password, returning the user they
+// belong to. An unknown email, a soft-deleted user and a wrong password are
+// indistinguishable. Only active users are authenticated: unverified and
+// suspended users are refused even with the right password.
+func (s *Service) AuthenticateUser(ctx context.Context, req *userpb.AuthenticateUserRequest) (*userpb.AuthenticateUserResponse, error) {
+	s.logger(ctx).Debug("Authenticating user")
+	// Unknown emails are hashed too, so every call takes a slot
+	if !s.hashing.acquire() {
+		return nil, errHashingBusy
+	}
+	defer s.hashing.release()
+	user, err := s.store.GetByEmail(ctx, req.Email)
+	if err == nil && user.Deleted() {
+		err = ErrNotFound
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		checkPassword(dummyPasswordHash(), req.Password)
+		return nil, reasonError(codes.Unauthenticated, ReasonInvalidCredentials, errInvalidCredentials.Error())
+	case err != nil:
+		return nil, storeError(err, "")
+	}
+	if err := user.authenticate(req.Password); err != nil {
+		return nil, reasonError(codes.Unauthenticated, ReasonInvalidCredentials, err.Error())
+	}
+
+	switch st := user.currentStatus(); st {
+	case StatusActive:
+	case StatusPendingVerification:
+		// Until the address is verified, whoever registered it may not be its owner
+		return nil, reasonError(codes.FailedPrecondition, ReasonEmailNotVerified, fmt.Sprintf("user %s has not verified their email address", user.ID))
+	default:
+		s.logger(ctx).WithFields(logrus.Fields{"user_id": user.ID, "status": st}).Info("Refused authentication of inactive user")
+		return nil, reasonError(codes.PermissionDenied, ReasonUserSuspended, fmt.Sprintf("user %s is %s", user.ID, st))
+	}
+	return &userpb.AuthenticateUserResponse{
+		UserId: user.ID,
+		Status: user.currentStatus().Proto(),
+	}, nil
+}
+
+// verificationError reports a token that cannot be redeemed as a field violation
+func verificationError(err error) error {
+	var v validation.Violations
+	v.Add("token", err.Error())
+	return v.Err()
+}
+
+// UpdateUser overwrites the fields named in the update mask
+func (s *Service) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	s.logger(ctx).WithFields(logrus.Fields{"user_id": req.UserId, "update_mask": req.UpdateMask.GetPaths()}).Debug("Updating user")
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	paths := req.UpdateMask.GetPaths()
+	if req.UpdateMask == nil {
+		// No mask: apply every field the caller populated
+		if req.Name != "" {
+			paths = append(paths, "name")
+		}
+		if req.Email != "" {
+			paths = append(paths, "email")
+		}
+	}
+	if len(paths) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "nothing to update")
+	}
+	var v validation.Violations
+	name, email := req.Name, req.Email
+	for _, p := range paths {
+		switch p {
+		case "name":
+			name = validation.Name(&v, "name", req.Name)
+		case "email":
+			email = validation.Email(&v, "email", req.Email)
+		default:
+			v.Add("update_mask", fmt.Sprintf("field %q cannot be updated", p))
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	// A new address must be verified before it can be used to log in, the
+	// same as at registration
+	secret, verification := newVerificationSecret(s.now().UTC(), s.live.Load().VerificationTokenTTL)
+	var emailChanged bool
+	user, err := s.store.Update(ctx, req.UserId, func(u *User) error {
+		if u.Deleted() {
+			return ErrNotFound
+		}
+		for _, p := range paths {
+			switch p {
+			case "name":
+				u.Name = name
+			case "email":
+				if emailChanged = validation.EmailKey(email) != validation.EmailKey(u.Email); !emailChanged {
+					u.Email = email
+				} else if err := u.changeEmail(email, verification); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err, req.UserId)
+	}
+	if emailChanged {
+		// As in CreateUser, the change stands; ResendVerification issues a new token
+		if err := s.sendVerification(ctx, user, secret); err != nil {
+			s.logger(ctx).WithField("user_id", user.ID).WithError(err).Error("Failed to send verification email")
+		}
+	}
+
+	return &userpb.UpdateUserResponse{
+		UserId: user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Status: user.currentStatus().Proto(),
+	}, nil
+}
+
+// DeleteUser soft-deletes a user by moving it to the deleted status and stamping deleted_at
+func (s *Service) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Deleting user")
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	now := s.now().UTC()
+	user, err := s.store.Update(ctx, req.UserId, func(u *User) error {
+		if u.Deleted() {
+			return ErrNotFound
+		}
+		if err := u.transition(StatusDeleted); err != nil {
+			return err
+		}
+		u.DeletedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err, req.UserId)
+	}
+
+	return &userpb.DeleteUserResponse{
+		UserId:    user.ID,
+		DeletedAt: timestamppb.New(*user.DeletedAt),
+	}, nil
+}
+
+// SuspendUser blocks an active or unverified user
+func (s *Service) SuspendUser(ctx context.Context, req *userpb.SuspendUserRequest) (*userpb.SuspendUserResponse, error) {
+	s.logger(ctx).WithFields(logrus.Fields{"user_id": req.UserId, "reason": req.Reason}).Debug("Suspending user")
+	user, err := s.changeStatus(ctx, req.UserId, StatusSuspended)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userpb.SuspendUserResponse{
+		UserId: user.ID,
+		Status: user.Status.Proto(),
+	}, nil
+}
+
+// ReactivateUser lifts a user's suspension, restoring them to active or, if
+// they never verified their email, to pending verification
+func (s *Service) ReactivateUser(ctx context.Context, req *userpb.ReactivateUserRequest) (*userpb.ReactivateUserResponse, error) {
+	s.logger(ctx).WithField("user_id", req.UserId).Debug("Reactivating user")
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	user, err := s.store.Update(ctx, req.UserId, (*User).reactivate)
+	if err != nil {
+		return nil, storeError(err, req.UserId)
+	}
+
+	return &userpb.ReactivateUserResponse{
+		UserId: user.ID,
+		Status: user.Status.Proto(),
+	}, nil
+}
+
+// changeStatus moves a user to status to
+func (s *Service) changeStatus(ctx context.Context, userID string, to Status) (*User, error) {
+	if userID == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	user, err := s.store.Update(ctx, userID, func(u *User) error {
+		return u.transition(to)
+	})
+	if err != nil {
+		return nil, storeError(err, userID)
+	}
+	return user, nil
+}
+
+// Page size bounds for ListUsers
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// ListUsers returns one page of users matching the request's filters
+func (s *Service) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	s.logger(ctx).WithFields(logrus.Fields{"page_size": req.PageSize, "order_by": req.OrderBy}).Debug("Listing users")
+	q, err := listQuery(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize < 0:
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	fingerprint := q.fingerprint()
+	if req.PageToken != "" {
+		tok, err := s.pageTokens.decode(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		if tok.Query != fingerprint {
+			return nil, status.Error(codes.InvalidArgument, "page_token was issued for different filters or ordering")
+		}
+		q.After = tok.Cursor
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	q.Limit = pageSize + 1
+	users, err := s.store.List(ctx, q)
+	if err != nil {
+		return nil, storeError(err, "")
+	}
+
+	resp := &userpb.ListUsersResponse{}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		resp.NextPageToken = s.pageTokens.encode(pageToken{
+			Query:  fingerprint,
+			Cursor: q.cursorFor(users[len(users)-1]),
+		})
+	}
+	for _, u := range users {
+		resp.Users = append(resp.Users, u.toProto())
+	}
+	return resp, nil
+}
+
+// listQuery translates the filter and ordering fields of a ListUsers request
+func listQuery(req *userpb.ListUsersRequest) (ListQuery, error) {
+	q := ListQuery{
+		NamePrefix:  req.NamePrefix,
+		EmailPrefix: req.EmailPrefix,
+		OrderBy:     OrderByCreatedAt,
+	}
+
+	if req.Status != userpb.UserStatus_USER_STATUS_UNSPECIFIED {
+		st, ok := statusFromProto(req.Status)
+		if !ok {
+			return q, fmt.Errorf("unknown status %v", req.Status)
+		}
+		q.Statuses = []Status{st}
+	} else if !req.ShowDeleted {
+		q.Statuses = []Status{StatusPendingVerification, StatusActive, StatusSuspended}
+	}
+
+	if req.OrderBy != "" {
+		fields := strings.Fields(req.OrderBy)
+		if len(fields) == 0 || len(fields) > 2 {
+			return q, fmt.Errorf("order_by %q: expected a single field", req.OrderBy)
+		}
+		switch fields[0] {
+		case OrderByCreatedAt, OrderByName:
+			q.OrderBy = fields[0]
+		default:
+			return q, fmt.Errorf("order_by %q: can only order by %s or %s", req.OrderBy, OrderByCreatedAt, OrderByName)
+		}
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "asc":
+			case "desc":
+				q.Descending = true
+			default:
+				return q, fmt.Errorf("order_by %q: direction must be asc or desc", req.OrderBy)
+			}
+		}
+	}
+	return q, nil
+}
+
+// ErrorInfo reasons attached to User service errors
+const (
+	errorDomain              = "userpb.UserService"
+	ReasonEmailAlreadyTaken  = "EMAIL_ALREADY_REGISTERED"
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonUserSuspended      = "USER_SUSPENDED"
+	ReasonEmailNotVerified   = "EMAIL_NOT_VERIFIED"
+	ReasonHashingBusy        = "PASSWORD_HASHING_BUSY"
+)
+
+// errHashingBusy refuses a password call while every hash slot is taken
+var errHashingBusy = reasonError(codes.ResourceExhausted, ReasonHashingBusy, "too many password checks in progress, retry shortly")
+
+// reasonError returns a status carrying an ErrorInfo with reason
+func reasonError(code codes.Code, reason, msg string) error {
+	st := status.New(code, msg)
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// storeError converts a UserStore error into a gRPC status
+func storeError(err error, userID string) error {
+	var conflict *EmailConflictError
+	var transition *TransitionError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Errorf(codes.NotFound, "user %s not found", userID)
+	case errors.As(err, &transition):
+		st := status.Newf(codes.FailedPrecondition, "user %s: %v", userID, transition)
+		withDetails, derr := st.WithDetails(&errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{{
+				Type:        "STATUS",
+				Subject:     "user/" + userID,
+				Description: fmt.Sprintf("user is %s", transition.From),
+			}},
+		})
+		if derr != nil {
+			return st.Err()
+		}
+		return withDetails.Err()
+	case errors.As(err, &conflict):
+		// The caller knows the address it sent; keeping it out of the message
+		// keeps it out of every log line that records the error
+		st := status.New(codes.AlreadyExists, "email is already registered")
+		withDetails, derr := st.WithDetails(
+			&errdetails.ErrorInfo{
+				Reason:   ReasonEmailAlreadyTaken,
+				Domain:   errorDomain,
+				Metadata: map[string]string{"email": conflict.Email},
+			},
+			&errdetails.ResourceInfo{
+				ResourceType: "user",
+				ResourceName: conflict.UserID,
+				Description:  "existing user with the same email address",
+			},
+		)
+		if derr != nil {
+			return st.Err()
+		}
+		return withDetails.Err()
+	case errors.Is(err, ErrEmailExists):
+		return status.Error(codes.AlreadyExists, "email is already registered")
+	default:
+		return status.Errorf(codes.Internal, "user store error: %v", err)
+	}
+}
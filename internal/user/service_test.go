@@ -2,17 +2,35 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/mail"
+	"github.com/mr1hm/grpc-demo/internal/mail/mailtest"
+	"github.com/mr1hm/grpc-demo/internal/tracing/tracingtest"
 	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func newTestService() *Service {
 	cfg := config.New(":50051", ":50052")
-	return NewService(cfg)
+	return NewServiceWithMailer(cfg, NewMemoryStore(), mailtest.NewRecorder())
 }
 
 func TestGetUser(t *testing.T) {
@@ -26,11 +44,10 @@ func TestGetUser(t *testing.T) {
 		{
 			name: "user exists",
 			setup: func(s *Service) {
-				s.users["user-1"] = &userpb.GetUserResponse{
-					UserId: "user-1",
-					Name:   "John",
-					Email:  "john@example.com",
-				}
+				s.store.Create(context.Background(), &User{
+					Name:  "John",
+					Email: "john@example.com",
+				})
 			},
 			userID: "user-1",
 			want: &userpb.GetUserResponse{
@@ -160,3 +177,1019 @@ func TestCreateUser_ThenGetUser(t *testing.T) {
 		t.Errorf("GetUser returned %+v, want matching created user %+v", got, created)
 	}
 }
+
+func seedUsers(t *testing.T, svc *Service, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := svc.CreateUser(context.Background(), &userpb.CreateUserRequest{
+			Name:  name,
+			Email: name + "@example.com",
+		}); err != nil {
+			t.Fatalf("CreateUser(%s) failed: %v", name, err)
+		}
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       *userpb.UpdateUserRequest
+		wantName  string
+		wantEmail string
+		wantErr   codes.Code
+	}{
+		{
+			name: "mask limits update to email",
+			req: &userpb.UpdateUserRequest{
+				UserId:     "user-1",
+				Name:       "ignored",
+				Email:      "new@example.com",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+			},
+			wantName:  "Alice",
+			wantEmail: "new@example.com",
+		},
+		{
+			name: "mask cannot clear a required field",
+			req: &userpb.UpdateUserRequest{
+				UserId:     "user-1",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+			},
+			wantErr: codes.InvalidArgument,
+		},
+		{
+			name: "updated email is validated",
+			req: &userpb.UpdateUserRequest{
+				UserId:     "user-1",
+				Email:      "not-an-email",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+			},
+			wantErr: codes.InvalidArgument,
+		},
+		{
+			name:      "no mask applies populated fields",
+			req:       &userpb.UpdateUserRequest{UserId: "user-1", Name: "Alicia"},
+			wantName:  "Alicia",
+			wantEmail: "Alice@example.com",
+		},
+		{
+			name:    "missing user id",
+			req:     &userpb.UpdateUserRequest{Name: "x"},
+			wantErr: codes.InvalidArgument,
+		},
+		{
+			name:    "nothing to update",
+			req:     &userpb.UpdateUserRequest{UserId: "user-1"},
+			wantErr: codes.InvalidArgument,
+		},
+		{
+			name: "immutable field in mask",
+			req: &userpb.UpdateUserRequest{
+				UserId:     "user-1",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"user_id"}},
+			},
+			wantErr: codes.InvalidArgument,
+		},
+		{
+			name:    "unknown user",
+			req:     &userpb.UpdateUserRequest{UserId: "user-99", Name: "x"},
+			wantErr: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService()
+			seedUsers(t, svc, "Alice")
+
+			got, err := svc.UpdateUser(context.Background(), tt.req)
+			if tt.wantErr != codes.OK {
+				if status.Code(err) != tt.wantErr {
+					t.Fatalf("expected code %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Name != tt.wantName || got.Email != tt.wantEmail {
+				t.Errorf("got name=%q email=%q, want name=%q email=%q", got.Name, got.Email, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestUpdateUser_EmailChange(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	mailer := mailtest.NewRecorder()
+	svc := NewServiceWithMailer(cfg, NewMemoryStore(), mailer)
+	created, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: sentToken(t, mailer, created.UserId)}); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	update := func(req *userpb.UpdateUserRequest) *userpb.UpdateUserResponse {
+		t.Helper()
+		req.UserId = created.UserId
+		resp, err := svc.UpdateUser(ctx, req)
+		if err != nil {
+			t.Fatalf("UpdateUser failed: %v", err)
+		}
+		return resp
+	}
+
+	// Renaming or re-sending the same address leaves the user active
+	if resp := update(&userpb.UpdateUserRequest{Name: "Annie", Email: "ann@EXAMPLE.com"}); resp.Status != userpb.UserStatus_USER_STATUS_ACTIVE {
+		t.Errorf("status after same-address update = %v, want ACTIVE", resp.Status)
+	}
+	if sent := mailer.Sent(); len(sent) != 1 {
+		t.Errorf("sent %d messages, want only the registration email", len(sent))
+	}
+
+	// A new address must be verified before it can be used to log in
+	resp := update(&userpb.UpdateUserRequest{Email: "unverified@example.com"})
+	if resp.Status != userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION {
+		t.Errorf("status after email change = %v, want PENDING_VERIFICATION", resp.Status)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 2 || sent[1].To != "unverified@example.com" {
+		t.Fatalf("sent = %+v, want a verification email to the new address", sent)
+	}
+	_, err = svc.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{Email: "unverified@example.com", Password: "correct horse"})
+	if st, _ := status.FromError(err); st.Code() != codes.FailedPrecondition || errorReason(st) != ReasonEmailNotVerified {
+		t.Errorf("login with unverified address: expected FailedPrecondition/%s, got %v", ReasonEmailNotVerified, err)
+	}
+
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: sentToken(t, mailer, created.UserId)}); err != nil {
+		t.Fatalf("VerifyEmail of the new address failed: %v", err)
+	}
+	if _, err := svc.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{Email: "unverified@example.com", Password: "correct horse"}); err != nil {
+		t.Errorf("login after verifying the new address failed: %v", err)
+	}
+
+	// A suspended user stays suspended, and reactivates to pending verification
+	if _, err := svc.SuspendUser(ctx, &userpb.SuspendUserRequest{UserId: created.UserId}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := update(&userpb.UpdateUserRequest{Email: "another@example.com"}); resp.Status != userpb.UserStatus_USER_STATUS_SUSPENDED {
+		t.Errorf("status after suspended email change = %v, want SUSPENDED", resp.Status)
+	}
+	reactivated, err := svc.ReactivateUser(ctx, &userpb.ReactivateUserRequest{UserId: created.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reactivated.Status != userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION {
+		t.Errorf("status after reactivation = %v, want PENDING_VERIFICATION", reactivated.Status)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService()
+	seedUsers(t, svc, "Alice", "Bob")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	resp, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{UserId: "user-1"})
+	if err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if !resp.DeletedAt.AsTime().Equal(now) {
+		t.Errorf("DeletedAt = %v, want %v", resp.DeletedAt.AsTime(), now)
+	}
+
+	if _, err := svc.GetUser(ctx, &userpb.GetUserRequest{UserId: "user-1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetUser after delete: expected NotFound, got %v", err)
+	}
+	if _, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{UserId: "user-1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("second DeleteUser: expected NotFound, got %v", err)
+	}
+	if _, err := svc.UpdateUser(ctx, &userpb.UpdateUserRequest{UserId: "user-1", Name: "x"}); status.Code(err) != codes.NotFound {
+		t.Errorf("UpdateUser after delete: expected NotFound, got %v", err)
+	}
+	if _, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DeleteUser without ID: expected InvalidArgument, got %v", err)
+	}
+
+	list, err := svc.ListUsers(ctx, &userpb.ListUsersRequest{})
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(list.Users) != 1 || list.Users[0].UserId != "user-2" {
+		t.Errorf("ListUsers = %v, want only user-2", list.Users)
+	}
+
+	all, err := svc.ListUsers(ctx, &userpb.ListUsersRequest{ShowDeleted: true})
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(all.Users) != 2 || all.Users[0].DeletedAt == nil {
+		t.Errorf("ListUsers(show_deleted) = %v, want both users with user-1 deleted", all.Users)
+	}
+}
+
+func TestListUsers_Pagination(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService()
+	seedUsers(t, svc, "u1", "u2", "u3", "u4", "u5")
+
+	var got []string
+	req := &userpb.ListUsersRequest{PageSize: 2}
+	for page := 0; ; page++ {
+		resp, err := svc.ListUsers(ctx, req)
+		if err != nil {
+			t.Fatalf("ListUsers failed: %v", err)
+		}
+		for _, u := range resp.Users {
+			got = append(got, u.UserId)
+		}
+		if page == 0 {
+			// Users created mid-iteration sort after the cursor and must not
+			// shift or duplicate the remaining pages.
+			seedUsers(t, svc, "late")
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+
+	want := []string{"user-1", "user-2", "user-3", "user-4", "user-5", "user-6"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paged IDs = %v, want %v", got, want)
+	}
+}
+
+func TestListUsers_InvalidRequests(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService()
+	seedUsers(t, svc, "u1", "u2", "u3")
+
+	first, err := svc.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: 1, OrderBy: "name"})
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	token := first.NextPageToken
+	if token == "" {
+		t.Fatal("expected a next_page_token")
+	}
+
+	tests := []struct {
+		name string
+		req  *userpb.ListUsersRequest
+	}{
+		{name: "negative page size", req: &userpb.ListUsersRequest{PageSize: -1}},
+		{name: "unknown order field", req: &userpb.ListUsersRequest{OrderBy: "email"}},
+		{name: "blank order", req: &userpb.ListUsersRequest{OrderBy: " \t"}},
+		{name: "bad order direction", req: &userpb.ListUsersRequest{OrderBy: "name sideways"}},
+		{name: "garbage token", req: &userpb.ListUsersRequest{PageToken: "not-a-token"}},
+		{name: "tampered token", req: &userpb.ListUsersRequest{OrderBy: "name", PageToken: "x" + token}},
+		{name: "token for different ordering", req: &userpb.ListUsersRequest{OrderBy: "name desc", PageToken: token}},
+		{name: "token for different filter", req: &userpb.ListUsersRequest{OrderBy: "name", NamePrefix: "u", PageToken: token}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.ListUsers(ctx, tt.req); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected InvalidArgument, got %v", err)
+			}
+		})
+	}
+
+	other := newTestService()
+	seedUsers(t, other, "u1", "u2")
+	if _, err := other.ListUsers(ctx, &userpb.ListUsersRequest{OrderBy: "name", PageToken: token}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("token signed by another key: expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestCreateUser_Validation(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      *userpb.CreateUserRequest
+		wantFields []string
+		wantName   string
+		wantEmail  string
+	}{
+		{
+			name:       "empty name and malformed email",
+			input:      &userpb.CreateUserRequest{Name: "", Email: "x"},
+			wantFields: []string{"name", "email"},
+		},
+		{
+			name:       "display name form is rejected",
+			input:      &userpb.CreateUserRequest{Name: "Ann", Email: "Ann <ann@example.com>"},
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "short password",
+			input:      &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com", Password: "hunter2"},
+			wantFields: []string{"password"},
+		},
+		{
+			name:      "input is normalized",
+			input:     &userpb.CreateUserRequest{Name: "  José ", Email: " jose@Example.COM "},
+			wantName:  "José",
+			wantEmail: "jose@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService()
+			got, err := svc.CreateUser(context.Background(), tt.input)
+
+			if tt.wantFields != nil {
+				st, _ := status.FromError(err)
+				if st.Code() != codes.InvalidArgument {
+					t.Fatalf("expected InvalidArgument, got %v", err)
+				}
+				var fields []string
+				for _, d := range st.Details() {
+					if br, ok := d.(*errdetails.BadRequest); ok {
+						for _, fv := range br.FieldViolations {
+							fields = append(fields, fv.Field)
+						}
+					}
+				}
+				if !slices.Equal(fields, tt.wantFields) {
+					t.Errorf("violations = %v, want %v", fields, tt.wantFields)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Name != tt.wantName || got.Email != tt.wantEmail {
+				t.Errorf("got name=%q email=%q, want name=%q email=%q", got.Name, got.Email, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	mailer := mailtest.NewRecorder()
+	svc := NewServiceWithMailer(cfg, NewMemoryStore(), mailer)
+	for _, req := range []*userpb.CreateUserRequest{
+		{Name: "Ann", Email: "ann@example.com", Password: "correct horse"},
+		{Name: "Bob", Email: "bob@example.com"},
+		{Name: "Cat", Email: "cat@example.com", Password: "correct horse"},
+		{Name: "Dan", Email: "dan@example.com", Password: "correct horse"},
+		{Name: "Eve", Email: "eve@example.com", Password: "correct horse"},
+	} {
+		created, err := svc.CreateUser(ctx, req)
+		if err != nil {
+			t.Fatalf("CreateUser(%s) failed: %v", req.Name, err)
+		}
+		if req.Name == "Eve" {
+			continue // left unverified
+		}
+		if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: sentToken(t, mailer, created.UserId)}); err != nil {
+			t.Fatalf("VerifyEmail(%s) failed: %v", req.Name, err)
+		}
+	}
+	if _, err := svc.SuspendUser(ctx, &userpb.SuspendUserRequest{UserId: "user-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{UserId: "user-4"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := svc.store.Get(ctx, "user-1")
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$v=19$") || strings.Contains(stored.PasswordHash, "correct horse") {
+		t.Errorf("stored password hash = %q, want an argon2id hash", stored.PasswordHash)
+	}
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantUserID string
+		wantErr    codes.Code
+		wantReason string
+	}{
+		{name: "correct password", email: "ann@example.com", password: "correct horse", wantUserID: "user-1"},
+		{name: "email in another case", email: "ANN@example.com", password: "correct horse", wantUserID: "user-1"},
+		{name: "wrong password", email: "ann@example.com", password: "Correct horse", wantErr: codes.Unauthenticated, wantReason: ReasonInvalidCredentials},
+		{name: "unknown email", email: "nobody@example.com", password: "correct horse", wantErr: codes.Unauthenticated, wantReason: ReasonInvalidCredentials},
+		{name: "user without a password", email: "bob@example.com", password: "", wantErr: codes.Unauthenticated, wantReason: ReasonInvalidCredentials},
+		{name: "suspended user", email: "cat@example.com", password: "correct horse", wantErr: codes.PermissionDenied, wantReason: ReasonUserSuspended},
+		{name: "deleted user", email: "dan@example.com", password: "correct horse", wantErr: codes.Unauthenticated, wantReason: ReasonInvalidCredentials},
+		{name: "unverified user", email: "eve@example.com", password: "correct horse", wantErr: codes.FailedPrecondition, wantReason: ReasonEmailNotVerified},
+		{name: "unverified user with a wrong password", email: "eve@example.com", password: "wrong horse", wantErr: codes.Unauthenticated, wantReason: ReasonInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{Email: tt.email, Password: tt.password})
+			st, _ := status.FromError(err)
+			if st.Code() != tt.wantErr {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != codes.OK {
+				if reason := errorReason(st); reason != tt.wantReason {
+					t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+				}
+				return
+			}
+			if got.UserId != tt.wantUserID {
+				t.Errorf("UserId = %q, want %q", got.UserId, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func TestPasswordHashConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	cfg.PasswordHashConcurrency = 1
+	svc := NewServiceWithStore(cfg, NewMemoryStore())
+
+	// Hold the only slot, as a hash in progress would
+	if !svc.hashing.acquire() {
+		t.Fatal("expected a free hash slot")
+	}
+	_, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Ann", Email: "ann@example.com", Password: "correct horse"})
+	if st, _ := status.FromError(err); st.Code() != codes.ResourceExhausted || errorReason(st) != ReasonHashingBusy {
+		t.Errorf("CreateUser: expected ResourceExhausted/%s, got %v", ReasonHashingBusy, err)
+	}
+	_, err = svc.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{Email: "nobody@example.com", Password: "correct horse"})
+	if st, _ := status.FromError(err); st.Code() != codes.ResourceExhausted || errorReason(st) != ReasonHashingBusy {
+		t.Errorf("AuthenticateUser: expected ResourceExhausted/%s, got %v", ReasonHashingBusy, err)
+	}
+	if _, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Bob", Email: "bob@example.com"}); err != nil {
+		t.Errorf("CreateUser without a password should not need a slot: %v", err)
+	}
+
+	svc.hashing.release()
+	_, err = svc.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{Email: "nobody@example.com", Password: "correct horse"})
+	if st, _ := status.FromError(err); st.Code() != codes.Unauthenticated {
+		t.Errorf("AuthenticateUser after release: expected Unauthenticated, got %v", err)
+	}
+	// Each call gave its slot back
+	if !svc.hashing.acquire() {
+		t.Error("hash slot was not released")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash := hashPassword("correct horse")
+	if hash == hashPassword("correct horse") {
+		t.Error("hashes of the same password should differ by salt")
+	}
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{name: "match", hash: hash, password: "correct horse", want: true},
+		{name: "mismatch", hash: hash, password: "correct horse "},
+		{name: "empty hash", hash: "", password: ""},
+		{name: "other algorithm", hash: strings.Replace(hash, "argon2id", "argon2i", 1), password: "correct horse"},
+		{name: "other version", hash: strings.Replace(hash, "v=19", "v=16", 1), password: "correct horse"},
+		{name: "truncated", hash: hash[:strings.LastIndexByte(hash, '$')], password: "correct horse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkPassword(tt.hash, tt.password); got != tt.want {
+				t.Errorf("checkPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateUser_DuplicateEmail(t *testing.T) {
+	svc := newTestService()
+	seedUsers(t, svc, "Alice")
+
+	_, err := svc.CreateUser(context.Background(), &userpb.CreateUserRequest{
+		Name:  "Imposter",
+		Email: "ALICE@example.com",
+	})
+	st, _ := status.FromError(err)
+	if st.Code() != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+
+	var resource *errdetails.ResourceInfo
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ResourceInfo:
+			resource = d
+		case *errdetails.ErrorInfo:
+			info = d
+		}
+	}
+	if resource == nil || resource.ResourceName != "user-1" {
+		t.Errorf("ResourceInfo = %v, want conflicting user-1", resource)
+	}
+	if info == nil || info.Reason != ReasonEmailAlreadyTaken {
+		t.Errorf("ErrorInfo = %v, want reason %s", info, ReasonEmailAlreadyTaken)
+	}
+}
+
+// errorReason returns the ErrorInfo reason attached to st, if any
+func errorReason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestSuspendAndReactivateUser(t *testing.T) {
+	ctx := context.Background()
+
+	suspend := func(svc *Service, id string) error {
+		_, err := svc.SuspendUser(ctx, &userpb.SuspendUserRequest{UserId: id, Reason: "abuse"})
+		return err
+	}
+	reactivate := func(svc *Service, id string) error {
+		_, err := svc.ReactivateUser(ctx, &userpb.ReactivateUserRequest{UserId: id})
+		return err
+	}
+	del := func(svc *Service, id string) error {
+		_, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{UserId: id})
+		return err
+	}
+	verify := func(svc *Service, id string) error {
+		for _, msg := range svc.mailer.(*mailtest.Recorder).Sent() {
+			for _, field := range strings.Fields(msg.Body) {
+				if strings.HasPrefix(field, id+".") {
+					_, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: field})
+					return err
+				}
+			}
+		}
+		return fmt.Errorf("no verification token sent for %s", id)
+	}
+
+	tests := []struct {
+		name       string
+		steps      []func(*Service, string) error
+		wantErr    codes.Code
+		wantStatus userpb.UserStatus
+	}{
+		{
+			name:       "suspend unverified user",
+			steps:      []func(*Service, string) error{suspend},
+			wantStatus: userpb.UserStatus_USER_STATUS_SUSPENDED,
+		},
+		{
+			name:       "reactivate suspended user",
+			steps:      []func(*Service, string) error{verify, suspend, reactivate},
+			wantStatus: userpb.UserStatus_USER_STATUS_ACTIVE,
+		},
+		{
+			name:       "reactivate suspended unverified user",
+			steps:      []func(*Service, string) error{suspend, reactivate},
+			wantStatus: userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
+		},
+		{
+			name:       "verify after reactivation",
+			steps:      []func(*Service, string) error{suspend, reactivate, verify},
+			wantStatus: userpb.UserStatus_USER_STATUS_ACTIVE,
+		},
+		{
+			name:       "verify while suspended",
+			steps:      []func(*Service, string) error{suspend, verify},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_SUSPENDED,
+		},
+		{
+			name:       "reactivate active user",
+			steps:      []func(*Service, string) error{verify, suspend, reactivate, reactivate},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_ACTIVE,
+		},
+		{
+			name:       "delete suspended user",
+			steps:      []func(*Service, string) error{suspend, del},
+			wantStatus: userpb.UserStatus_USER_STATUS_DELETED,
+		},
+		{
+			name:       "suspend twice",
+			steps:      []func(*Service, string) error{suspend, suspend},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_SUSPENDED,
+		},
+		{
+			name:       "reactivate unverified user",
+			steps:      []func(*Service, string) error{reactivate},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
+		},
+		{
+			name:       "suspend deleted user",
+			steps:      []func(*Service, string) error{del, suspend},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_DELETED,
+		},
+		{
+			name:       "reactivate deleted user",
+			steps:      []func(*Service, string) error{del, reactivate},
+			wantErr:    codes.FailedPrecondition,
+			wantStatus: userpb.UserStatus_USER_STATUS_DELETED,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService()
+			seedUsers(t, svc, "Alice")
+
+			var err error
+			for _, step := range tt.steps {
+				if err = step(svc, "user-1"); err != nil {
+					break
+				}
+			}
+			if status.Code(err) != tt.wantErr {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == codes.FailedPrecondition {
+				st, _ := status.FromError(err)
+				var failure *errdetails.PreconditionFailure
+				for _, d := range st.Details() {
+					if d, ok := d.(*errdetails.PreconditionFailure); ok {
+						failure = d
+					}
+				}
+				if failure == nil || len(failure.Violations) != 1 || failure.Violations[0].Subject != "user/user-1" {
+					t.Errorf("PreconditionFailure = %v, want a violation for user/user-1", failure)
+				}
+			}
+
+			list, err := svc.ListUsers(ctx, &userpb.ListUsersRequest{ShowDeleted: true})
+			if err != nil {
+				t.Fatalf("ListUsers failed: %v", err)
+			}
+			if len(list.Users) != 1 || list.Users[0].Status != tt.wantStatus {
+				t.Errorf("ListUsers = %v, want user-1 with status %v", list.Users, tt.wantStatus)
+			}
+		})
+	}
+
+	svc := newTestService()
+	if err := suspend(svc, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SuspendUser without ID: expected InvalidArgument, got %v", err)
+	}
+	if err := reactivate(svc, "user-9"); status.Code(err) != codes.NotFound {
+		t.Errorf("ReactivateUser unknown user: expected NotFound, got %v", err)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	cfg.VerificationTokenTTL = time.Hour
+	mailer := mailtest.NewRecorder()
+	store := NewMemoryStore()
+	svc := NewServiceWithMailer(cfg, store, mailer)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	created, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if created.Status != userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION {
+		t.Errorf("new user status = %v, want PENDING_VERIFICATION", created.Status)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "alice@example.com" {
+		t.Fatalf("sent = %+v, want one message to alice@example.com", sent)
+	}
+	token := sentToken(t, mailer, created.UserId)
+	_, secret, _ := parseVerificationToken(token)
+
+	stored, _ := store.Get(ctx, created.UserId)
+	if stored.Verification == nil || stored.Verification.TokenHash == secret || strings.Contains(stored.Verification.TokenHash, secret) {
+		t.Errorf("stored verification = %+v, want only a hash of the token", stored.Verification)
+	}
+	if !stored.Verification.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want %v", stored.Verification.ExpiresAt, now.Add(time.Hour))
+	}
+
+	bad := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "malformed", token: "no-separator"},
+		{name: "wrong secret", token: verificationToken(created.UserId, "guess")},
+		{name: "unknown user", token: verificationToken("user-99", secret)},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: tt.token})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expected InvalidArgument, got %v", err)
+			}
+		})
+	}
+
+	resp, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: token})
+	if err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if resp.UserId != created.UserId || resp.Status != userpb.UserStatus_USER_STATUS_ACTIVE {
+		t.Errorf("VerifyEmail = %v, want %s active", resp, created.UserId)
+	}
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: token}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("reused token: expected InvalidArgument, got %v", err)
+	}
+	if stored, _ := store.Get(ctx, created.UserId); stored.Verification != nil {
+		t.Errorf("verification not cleared after use: %+v", stored.Verification)
+	}
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	cfg.VerificationTokenTTL = time.Hour
+	mailer := mailtest.NewRecorder()
+	svc := NewServiceWithMailer(cfg, NewMemoryStore(), mailer)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	created, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token := sentToken(t, mailer, created.UserId)
+
+	now = now.Add(time.Hour + time.Second)
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: token}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expired token: expected InvalidArgument, got %v", err)
+	}
+	got, err := svc.GetUser(ctx, &userpb.GetUserRequest{UserId: created.UserId})
+	if err != nil {
+		t.Fatalf("GetUser failed: %v", err)
+	}
+	if got.Status != userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION {
+		t.Errorf("status = %v, want PENDING_VERIFICATION", got.Status)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	cfg.VerificationTokenTTL = time.Hour
+	outbox := mailtest.NewRecorder()
+	mailer := &failingMailer{Mailer: outbox, err: errors.New("smtp down")}
+	svc := NewServiceWithMailer(cfg, NewMemoryStore(), mailer)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	resend := func(email string) error {
+		_, err := svc.ResendVerification(ctx, &userpb.ResendVerificationRequest{Email: email})
+		return err
+	}
+
+	// The registration survives a failed send, but there is no token to redeem
+	created, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := resend("alice@example.com"); status.Code(err) != codes.Unavailable {
+		t.Errorf("resend while mail is down: expected Unavailable, got %v", err)
+	}
+	if sent := outbox.Sent(); len(sent) != 0 {
+		t.Fatalf("sent = %+v, want nothing while mail is down", sent)
+	}
+
+	mailer.err = nil
+	if err := resend("alice@example.com"); err != nil {
+		t.Fatalf("ResendVerification failed: %v", err)
+	}
+	first := sentToken(t, outbox, created.UserId)
+
+	// A resend after expiry replaces the token, and only the new one works
+	now = now.Add(2 * time.Hour)
+	if err := resend(" alice@example.com "); err != nil {
+		t.Fatalf("ResendVerification failed: %v", err)
+	}
+	second := sentToken(t, outbox, created.UserId)
+	if second == first {
+		t.Fatal("resend reused the previous token")
+	}
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: first}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("replaced token: expected InvalidArgument, got %v", err)
+	}
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: second}); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		email   string
+		wantErr codes.Code
+	}{
+		{name: "already verified", email: "alice@example.com"},
+		{name: "unknown email", email: "bob@example.com"},
+		{name: "invalid email", email: "not an email", wantErr: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(outbox.Sent())
+			if err := resend(tt.email); status.Code(err) != tt.wantErr {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if sent := outbox.Sent(); len(sent) != before {
+				t.Errorf("sent %d new messages, want none", len(sent)-before)
+			}
+		})
+	}
+}
+
+// failingMailer fails every Send while err is set
+type failingMailer struct {
+	mail.Mailer
+	err error
+}
+
+func (m *failingMailer) Send(ctx context.Context, msg mail.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.Mailer.Send(ctx, msg)
+}
+
+// sentToken returns the last verification token emailed for userID
+func sentToken(t *testing.T, mailer *mailtest.Recorder, userID string) string {
+	t.Helper()
+	var token string
+	for _, msg := range mailer.Sent() {
+		for _, field := range strings.Fields(msg.Body) {
+			if strings.HasPrefix(field, userID+".") {
+				token = field
+			}
+		}
+	}
+	if token == "" {
+		t.Fatalf("no verification token sent for %s", userID)
+	}
+	return token
+}
+
+func TestStoreSpans(t *testing.T) {
+	exporter := tracingtest.NewInMemory()
+	ctx := context.Background()
+	svc := newTestService()
+
+	created, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	svc.GetUser(ctx, &userpb.GetUserRequest{UserId: "user-404"})
+	svc.ReactivateUser(ctx, &userpb.ReactivateUserRequest{UserId: created.UserId})
+
+	tests := []struct {
+		name      string
+		wantID    string
+		wantEvent string
+	}{
+		{name: "UserStore.Create", wantID: created.UserId},
+		{name: "UserStore.Get", wantID: "user-404"},                                      // Not found is not a fault
+		{name: "UserStore.Update", wantID: created.UserId, wantEvent: "update rejected"}, // Pending users cannot be reactivated
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != len(tests) {
+		t.Fatalf("got %d spans, want %d", len(spans), len(tests))
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name != tt.name {
+			t.Errorf("span %d = %q, want %q", i, span.Name, tt.name)
+			continue
+		}
+		if span.Status.Code != otelcodes.Unset {
+			t.Errorf("%s status = %v, want unset", tt.name, span.Status)
+		}
+		var id string
+		for _, kv := range span.Attributes {
+			if kv.Key == userIDKey {
+				id = kv.Value.AsString()
+			}
+		}
+		if id != tt.wantID {
+			t.Errorf("%s user.id = %q, want %q", tt.name, id, tt.wantID)
+		}
+		if tt.wantEvent != "" && (len(span.Events) != 1 || span.Events[0].Name != tt.wantEvent) {
+			t.Errorf("%s events = %v, want %q", tt.name, span.Events, tt.wantEvent)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.New(":50051", ":50052")
+	mailer := mailtest.NewRecorder()
+	svc := NewServiceWithMailer(cfg, NewMemoryStore(), mailer)
+
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		if _, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: name, Email: strings.ToLower(name) + "@example.com"}); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	if _, err := svc.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Token: sentToken(t, mailer, "user-1")}); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if _, err := svc.DeleteUser(ctx, &userpb.DeleteUserRequest{UserId: "user-2"}); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	want := `
+# HELP user_accounts Users in the store by status, counted at scrape time.
+# TYPE user_accounts gauge
+user_accounts{status="active"} 1
+user_accounts{status="deleted"} 1
+user_accounts{status="pending_verification"} 1
+user_accounts{status="suspended"} 0
+# HELP user_email_verifications_total Email addresses verified through VerifyEmail.
+# TYPE user_email_verifications_total counter
+user_email_verifications_total 1
+# HELP user_registrations_total Users registered through CreateUser.
+# TYPE user_registrations_total counter
+user_registrations_total 3
+`
+	if err := testutil.CollectAndCompare(svc.Collector(), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	cfg := config.New(":0", ":0")
+	svc := NewServiceWithStore(cfg, NewMemoryStore())
+	t.Cleanup(func() { svc.Close() })
+	lis := bufconn.Listen(1 << 20)
+	if _, err := svc.Start(t.Context(), lis); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	tests := []struct {
+		service  string
+		wantCode codes.Code
+	}{
+		{service: ""},
+		{service: "userpb.UserService"},
+		{service: "gatewaypb.GatewayService", wantCode: codes.NotFound},
+	}
+	for _, tt := range tests {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+		if status.Code(err) != tt.wantCode {
+			t.Errorf("Check(%q) error = %v, want %s", tt.service, err, tt.wantCode)
+			continue
+		}
+		if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Check(%q) = %s, want SERVING", tt.service, resp.Status)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	cfg := config.New("127.0.0.1:0", ":0")
+	cfg.SetOutput(io.Discard)
+
+	// Instances on ephemeral ports do not collide
+	var addrs []string
+	for range 2 {
+		svc := NewServiceWithStore(cfg, NewMemoryStore())
+		t.Cleanup(func() { svc.Close() })
+		server, err := svc.Start(t.Context(), nil)
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		addrs = append(addrs, server.Addr().String())
+	}
+	if addrs[0] == addrs[1] {
+		t.Fatalf("both instances listen on %s", addrs[0])
+	}
+
+	// A port in use is an error, not an exit
+	taken := config.New(addrs[0], ":0")
+	taken.SetOutput(io.Discard)
+	svc := NewServiceWithStore(taken, NewMemoryStore())
+	t.Cleanup(func() { svc.Close() })
+	if _, err := svc.Start(t.Context(), nil); err == nil {
+		t.Errorf("Start on %s in use succeeded", addrs[0])
+	}
+}
@@ -0,0 +1,309 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/mr1hm/grpc-demo/internal/validation"
+)
+
+// SQLStore keeps users in a relational database through database/sql. IDs
+// come from the database's own sequence, so any number of User service
+// replicas can share one database without handing out the same ID twice.
+// The SQL is written for SQLite.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQLStore connects to the database and runs any pending migrations.
+// For SQLite, a DSN such as "file:users.db?_txlock=immediate&_busy_timeout=5000"
+// lets several replicas share the file safely.
+func OpenSQLStore(ctx context.Context, driver, dsn string) (*SQLStore, error) {
+	if dsn == "" {
+		return nil, errors.New("sql store requires a DSN")
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", driver, err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s database: %w", driver, err)
+	}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStore{db: db}, nil
+}
+
+const userColumns = `id, name, email, status, created_at, deleted_at, verification_token_hash, verification_expires_at, password_hash`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	u := &User{}
+	var createdAt int64
+	var deletedAt sql.NullTime
+	var tokenHash sql.NullString
+	var expiresAt sql.NullInt64
+	var passwordHash sql.NullString
+	if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Status, &createdAt, &deletedAt, &tokenHash, &expiresAt, &passwordHash); err != nil {
+		return nil, err
+	}
+	if createdAt != 0 {
+		u.CreatedAt = time.Unix(0, createdAt).UTC()
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time
+		u.DeletedAt = &t
+	}
+	if tokenHash.Valid {
+		u.Verification = &Verification{
+			TokenHash: tokenHash.String,
+			ExpiresAt: time.Unix(0, expiresAt.Int64).UTC(),
+		}
+	}
+	u.PasswordHash = passwordHash.String
+	return u, nil
+}
+
+// verificationColumns returns the values stored for u's outstanding verification, if any
+func verificationColumns(u *User) (sql.NullString, sql.NullInt64) {
+	if u.Verification == nil {
+		return sql.NullString{}, sql.NullInt64{}
+	}
+	return sql.NullString{String: u.Verification.TokenHash, Valid: true},
+		sql.NullInt64{Int64: u.Verification.ExpiresAt.UnixNano(), Valid: true}
+}
+
+// passwordColumn returns the value stored for u's password hash
+func passwordColumn(u *User) sql.NullString {
+	return sql.NullString{String: u.PasswordHash, Valid: u.PasswordHash != ""}
+}
+
+// Get returns the user with the given ID
+func (s *SQLStore) Get(ctx context.Context, id string) (*User, error) {
+	return s.get(ctx, s.db, id)
+}
+
+// GetByEmail returns the user whose email key matches email's
+func (s *SQLStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email_key = ?`, validation.EmailKey(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return u, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLStore) get(ctx context.Context, q queryRower, id string) (*User, error) {
+	u, err := scanUser(q.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return u, nil
+}
+
+// Create inserts u and sets its ID from the row's database-assigned sequence number
+func (s *SQLStore) Create(ctx context.Context, u *User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The row is inserted under a placeholder ID unique to this transaction,
+	// then renamed once the sequence number is known.
+	var seq int64
+	tokenHash, expiresAt := verificationColumns(u)
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (id, name, email, email_key, status, created_at, verification_token_hash, verification_expires_at, password_hash)
+		VALUES ('pending-' || hex(randomblob(16)), ?, ?, ?, ?, ?, ?, ?, ?) RETURNING seq`,
+		u.Name, u.Email, validation.EmailKey(u.Email), u.currentStatus(), unixNano(u.CreatedAt), tokenHash, expiresAt, passwordColumn(u),
+	).Scan(&seq)
+	if err != nil {
+		return s.translateError(ctx, err, u.Email)
+	}
+
+	id := fmt.Sprintf("user-%d", seq)
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET id = ? WHERE seq = ?`, id, seq); err != nil {
+		return fmt.Errorf("assign user id: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit user: %w", err)
+	}
+
+	u.ID = id
+	return nil
+}
+
+// Update reads, modifies and writes the user inside a single transaction
+func (s *SQLStore) Update(ctx context.Context, id string, fn func(*User) error) (*User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	u, err := s.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(u); err != nil {
+		return nil, err
+	}
+	u.ID = id
+
+	var deletedAt sql.NullTime
+	if u.DeletedAt != nil {
+		deletedAt = sql.NullTime{Time: *u.DeletedAt, Valid: true}
+	}
+	tokenHash, expiresAt := verificationColumns(u)
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET name = ?, email = ?, email_key = ?, status = ?, deleted_at = ?,
+		verification_token_hash = ?, verification_expires_at = ?, password_hash = ? WHERE id = ?`,
+		u.Name, u.Email, validation.EmailKey(u.Email), u.currentStatus(), deletedAt, tokenHash, expiresAt, passwordColumn(u), id,
+	); err != nil {
+		return nil, s.translateError(ctx, err, u.Email)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit user: %w", err)
+	}
+	return u, nil
+}
+
+// List returns the users selected by q using keyset pagination
+func (s *SQLStore) List(ctx context.Context, q ListQuery) ([]*User, error) {
+	var where []string
+	var args []any
+
+	if len(q.Statuses) > 0 {
+		where = append(where, `status IN (?`+strings.Repeat(`, ?`, len(q.Statuses)-1)+`)`)
+		for _, st := range q.Statuses {
+			args = append(args, st)
+		}
+	}
+	if q.NamePrefix != "" {
+		where = append(where, `name LIKE ? ESCAPE '\'`)
+		args = append(args, likePrefix(q.NamePrefix))
+	}
+	if q.EmailPrefix != "" {
+		where = append(where, `email LIKE ? ESCAPE '\'`)
+		args = append(args, likePrefix(q.EmailPrefix))
+	}
+
+	key := "created_at"
+	if q.OrderBy == OrderByName {
+		key = "name"
+	}
+	cmp, dir := ">", "ASC"
+	if q.Descending {
+		cmp, dir = "<", "DESC"
+	}
+	if q.After != nil {
+		var after any = q.After.CreatedAt
+		if q.OrderBy == OrderByName {
+			after = q.After.Name
+		}
+		where = append(where, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND seq %[2]s ?))`, key, cmp))
+		args = append(args, after, after, q.After.Seq)
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY %[1]s %[2]s, seq %[2]s`, key, dir)
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	return users, nil
+}
+
+// CountByStatus counts users with a single GROUP BY, using the status index
+func (s *SQLStore) CountByStatus(ctx context.Context) (map[Status]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM users GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("count users: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[Status]int)
+	for rows.Next() {
+		var st Status
+		var n int
+		if err := rows.Scan(&st, &n); err != nil {
+			return nil, fmt.Errorf("scan user count: %w", err)
+		}
+		counts[st] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("count users: %w", err)
+	}
+	return counts, nil
+}
+
+// likePrefix escapes LIKE wildcards in prefix and appends a trailing %
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// Close closes the database connection pool
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// translateError maps a unique-email violation onto an *EmailConflictError
+// naming the current holder, and wraps anything else.
+func (s *SQLStore) translateError(ctx context.Context, err error, email string) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("write user: %w", err)
+	}
+
+	conflict := &EmailConflictError{Email: email}
+	// Best effort: the conflict is reported even if the holder cannot be read
+	s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE email_key = ?`, validation.EmailKey(email)).Scan(&conflict.UserID)
+	return conflict
+}
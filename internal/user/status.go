@@ -0,0 +1,94 @@
+package user
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+)
+
+// Status is where a user is in its lifecycle
+type Status string
+
+const (
+	StatusPendingVerification Status = "pending_verification"
+	StatusActive              Status = "active"
+	StatusSuspended           Status = "suspended"
+	StatusDeleted             Status = "deleted"
+)
+
+// transitions lists the statuses each status may move to. Deleted is terminal.
+var transitions = map[Status][]Status{
+	StatusPendingVerification: {StatusActive, StatusSuspended, StatusDeleted},
+	StatusActive:              {StatusPendingVerification, StatusSuspended, StatusDeleted},
+	StatusSuspended:           {StatusPendingVerification, StatusActive, StatusDeleted},
+}
+
+// TransitionError reports a status change the lifecycle does not allow
+type TransitionError struct {
+	From, To Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change status from %s to %s", e.From, e.To)
+}
+
+// CanTransition reports whether a user in status from may move to status to
+func CanTransition(from, to Status) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// transition moves u to status to, or returns a *TransitionError
+func (u *User) transition(to Status) error {
+	from := u.currentStatus()
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	u.Status = to
+	return nil
+}
+
+// reactivate lifts u's suspension. A user whose email is still unverified
+// goes back to pending verification rather than straight to active.
+func (u *User) reactivate() error {
+	if from := u.currentStatus(); from != StatusSuspended {
+		return &TransitionError{From: from, To: StatusActive}
+	}
+	if u.Verification != nil {
+		return u.transition(StatusPendingVerification)
+	}
+	return u.transition(StatusActive)
+}
+
+// currentStatus fills in the status of users stored before statuses existed
+func (u *User) currentStatus() Status {
+	if u.Status != "" {
+		return u.Status
+	}
+	if u.DeletedAt != nil {
+		return StatusDeleted
+	}
+	return StatusActive
+}
+
+var statusToProto = map[Status]userpb.UserStatus{
+	StatusPendingVerification: userpb.UserStatus_USER_STATUS_PENDING_VERIFICATION,
+	StatusActive:              userpb.UserStatus_USER_STATUS_ACTIVE,
+	StatusSuspended:           userpb.UserStatus_USER_STATUS_SUSPENDED,
+	StatusDeleted:             userpb.UserStatus_USER_STATUS_DELETED,
+}
+
+// Proto returns the wire form of s
+func (s Status) Proto() userpb.UserStatus {
+	return statusToProto[s]
+}
+
+// statusFromProto returns the Status for a wire value, or false for UNSPECIFIED and unknown values
+func statusFromProto(p userpb.UserStatus) (Status, bool) {
+	for s, v := range statusToProto {
+		if v == p {
+			return s, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,176 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+	"github.com/mr1hm/grpc-demo/internal/validation"
+	"github.com/mr1hm/grpc-demo/proto/userpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Supported values for config.Config.StoreDriver
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreSQL    = "sql"
+)
+
+// ErrNotFound is returned by a UserStore when no user has the requested ID
+var ErrNotFound = errors.New("user not found")
+
+// ErrEmailExists matches any *EmailConflictError
+var ErrEmailExists = errors.New("email already registered")
+
+// EmailConflictError reports that another user already holds an email address.
+// Addresses are compared by validation.EmailKey, so case and Unicode form do not
+// matter. Soft-deleted users keep their address reserved.
+type EmailConflictError struct {
+	Email  string
+	UserID string // The user holding the address, if known
+}
+
+func (e *EmailConflictError) Error() string {
+	if e.UserID == "" {
+		return fmt.Sprintf("email %s already registered", e.Email)
+	}
+	return fmt.Sprintf("email %s already registered to %s", e.Email, e.UserID)
+}
+
+// Is makes errors.Is(err, ErrEmailExists) true for conflicts
+func (e *EmailConflictError) Is(target error) bool {
+	return target == ErrEmailExists
+}
+
+// User is the stored representation of a user
+type User struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Status    Status     `json:"status,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Verification is set while the user's email address is unverified
+	Verification *Verification `json:"verification,omitempty"`
+
+	// PasswordHash is the argon2id hash of the user's password, if they set one
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// Deleted reports whether the user has been soft-deleted
+func (u *User) Deleted() bool {
+	return u.currentStatus() == StatusDeleted
+}
+
+// UserStore persists users for the User service
+type UserStore interface {
+	// Get returns the user with the given ID or ErrNotFound
+	Get(ctx context.Context, id string) (*User, error)
+	// GetByEmail returns the user holding email, compared by
+	// validation.EmailKey, or ErrNotFound
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	// Create assigns a new ID to u and stores it
+	Create(ctx context.Context, u *User) error
+	// Update applies fn to the stored user atomically and returns the result.
+	// If fn returns an error nothing is written and that error is returned.
+	Update(ctx context.Context, id string, fn func(*User) error) (*User, error)
+	// List returns the users selected by q, in q's order
+	List(ctx context.Context, q ListQuery) ([]*User, error)
+	// CountByStatus returns how many users are in each status. Statuses
+	// without users may be missing.
+	CountByStatus(ctx context.Context) (map[Status]int, error)
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// NewStore opens the UserStore selected by cfg.StoreDriver
+func NewStore(cfg *config.Config) (UserStore, error) {
+	switch cfg.StoreDriver {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreFile:
+		fs, err := OpenFileStore(cfg.StorePath, FileStoreOptions{
+			SnapshotEvery:       cfg.StoreSnapshotEvery,
+			TruncateCorruptTail: cfg.StoreTruncateCorruptLog,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rec := fs.Recovered(); rec != nil {
+			cfg.Warnf("User store recovered by discarding log tail: %v", rec)
+		}
+		return fs, nil
+	case StoreSQL:
+		return OpenSQLStore(context.Background(), cfg.StoreSQLDriver, cfg.StoreDSN)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+	}
+}
+
+func (u *User) toProto() *userpb.User {
+	pb := &userpb.User{
+		UserId: u.ID,
+		Name:   u.Name,
+		Email:  u.Email,
+		Status: u.currentStatus().Proto(),
+	}
+	if !u.CreatedAt.IsZero() {
+		pb.CreatedAt = timestamppb.New(u.CreatedAt)
+	}
+	if u.DeletedAt != nil {
+		pb.DeletedAt = timestamppb.New(*u.DeletedAt)
+	}
+	return pb
+}
+
+// idSeq extracts the sequence number from a "user-N" ID, or 0 if it has another form
+func idSeq(id string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "user-"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// emailIndex maps each email key to the ID of the user holding it, for stores
+// that enforce uniqueness in memory.
+type emailIndex map[string]string
+
+// check returns an *EmailConflictError if email is held by a user other than id
+func (idx emailIndex) check(id, email string) error {
+	if holder, taken := idx[validation.EmailKey(email)]; taken && holder != id {
+		return &EmailConflictError{Email: email, UserID: holder}
+	}
+	return nil
+}
+
+// lookup returns the ID of the user holding email
+func (idx emailIndex) lookup(email string) (string, bool) {
+	id, ok := idx[validation.EmailKey(email)]
+	return id, ok
+}
+
+// move re-points the index after user id changes its email from old to new
+func (idx emailIndex) move(id, old, new string) {
+	if old != "" && idx[validation.EmailKey(old)] == id {
+		delete(idx, validation.EmailKey(old))
+	}
+	idx[validation.EmailKey(new)] = id
+}
+
+// statusCounts tallies users per status, for stores that keep users in memory
+type statusCounts map[Status]int
+
+// move re-counts a user changing from old to new; old is nil for a new user
+func (c statusCounts) move(old, new *User) {
+	if old != nil {
+		c[old.currentStatus()]--
+	}
+	c[new.currentStatus()]++
+}
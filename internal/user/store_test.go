@@ -0,0 +1,655 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mr1hm/grpc-demo/internal/config"
+)
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  string
+		path    string
+		dsn     string
+		wantErr bool
+	}{
+		{name: "default is memory", driver: ""},
+		{name: "memory", driver: StoreMemory},
+		{name: "file", driver: StoreFile, path: t.TempDir()},
+		{name: "file without path", driver: StoreFile, wantErr: true},
+		{name: "sql", driver: StoreSQL, dsn: "file:" + filepath.Join(t.TempDir(), "users.db")},
+		{name: "sql without dsn", driver: StoreSQL, wantErr: true},
+		{name: "unknown driver", driver: "redis", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New(":50051", ":50052")
+			cfg.StoreDriver = tt.driver
+			cfg.StorePath = tt.path
+			cfg.StoreDSN = tt.dsn
+
+			store, err := NewStore(cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			store.Close()
+		})
+	}
+}
+
+func createUsers(t *testing.T, store UserStore, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := store.Create(context.Background(), &User{Name: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("Create(%s) failed: %v", name, err)
+		}
+	}
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapshotEvery int
+		closeFirst    bool
+	}{
+		{name: "replay from log only", snapshotEvery: 100},
+		{name: "replay snapshot plus log", snapshotEvery: 2},
+		{name: "clean close compacts", snapshotEvery: 100, closeFirst: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			opts := FileStoreOptions{SnapshotEvery: tt.snapshotEvery}
+
+			store, err := OpenFileStore(dir, opts)
+			if err != nil {
+				t.Fatalf("OpenFileStore failed: %v", err)
+			}
+			createUsers(t, store, "Alice", "Bob", "Carol")
+			if tt.closeFirst {
+				store.Close()
+			} else {
+				// Simulate a crash: drop the handle without compacting
+				store.wal.Close()
+			}
+
+			reopened, err := OpenFileStore(dir, opts)
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer reopened.Close()
+
+			got, err := reopened.Get(ctx, "user-2")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if got.Name != "Bob" {
+				t.Errorf("Name = %q, want %q", got.Name, "Bob")
+			}
+			if counts, _ := reopened.CountByStatus(ctx); counts[StatusActive] != 3 || len(counts) != 1 {
+				t.Errorf("CountByStatus = %v, want 3 active", counts)
+			}
+
+			next := &User{Name: "Dave"}
+			if err := reopened.Create(ctx, next); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if next.ID != "user-4" {
+				t.Errorf("ID = %q, want %q", next.ID, "user-4")
+			}
+
+			if _, err := reopened.Get(ctx, "user-9"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStore_CorruptTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+	}{
+		{
+			name: "torn header",
+			corrupt: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0})
+			},
+		},
+		{
+			name: "torn payload",
+			corrupt: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0, 50, 1, 2, 3, 4, '{'})
+			},
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, path string) {
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				raw[len(raw)-2] ^= 0xff
+				if err := os.WriteFile(path, raw, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := OpenFileStore(dir, FileStoreOptions{})
+			if err != nil {
+				t.Fatalf("OpenFileStore failed: %v", err)
+			}
+			createUsers(t, store, "Alice", "Bob")
+			store.wal.Close()
+			tt.corrupt(t, filepath.Join(dir, walFile))
+
+			_, err = OpenFileStore(dir, FileStoreOptions{})
+			var corrupt *CorruptLogError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("expected *CorruptLogError, got %v", err)
+			}
+
+			repaired, err := OpenFileStore(dir, FileStoreOptions{TruncateCorruptTail: true})
+			if err != nil {
+				t.Fatalf("open with truncation failed: %v", err)
+			}
+			defer repaired.Close()
+			if repaired.Recovered() == nil {
+				t.Error("expected Recovered() to report the discarded tail")
+			}
+			if _, err := repaired.Get(context.Background(), "user-1"); err != nil {
+				t.Errorf("user-1 should survive truncation: %v", err)
+			}
+
+			next := &User{Name: "Carol"}
+			if err := repaired.Create(context.Background(), next); err != nil {
+				t.Fatalf("Create after truncation failed: %v", err)
+			}
+			if next.ID == "user-1" {
+				t.Errorf("ID %q was reissued", next.ID)
+			}
+		})
+	}
+}
+
+func appendBytes(t *testing.T, path string, b []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestSQLStore(t *testing.T, path string) *SQLStore {
+	t.Helper()
+	store, err := OpenSQLStore(context.Background(), "sqlite3", "file:"+path+"?_txlock=immediate&_busy_timeout=10000")
+	if err != nil {
+		t.Fatalf("OpenSQLStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLStore_MigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	first := openTestSQLStore(t, path)
+	createUsers(t, first, "Alice")
+	first.Close()
+
+	second := openTestSQLStore(t, path)
+	var applied int
+	if err := second.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatalf("query schema_migrations: %v", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", applied, len(migrations))
+	}
+	if _, err := second.Get(context.Background(), "user-1"); err != nil {
+		t.Errorf("user-1 should survive reopen: %v", err)
+	}
+}
+
+func TestSQLStore_ReplicasShareIDSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	replicas := []*SQLStore{openTestSQLStore(t, path), openTestSQLStore(t, path)}
+
+	const perReplica = 20
+	ids := make(chan string, perReplica*len(replicas))
+	errs := make(chan error, perReplica*len(replicas))
+	var wg sync.WaitGroup
+	for r, store := range replicas {
+		for i := 0; i < perReplica; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				u := &User{Name: "n", Email: fmt.Sprintf("r%d-%d@example.com", r, i)}
+				if err := store.Create(context.Background(), u); err != nil {
+					errs <- err
+					return
+				}
+				ids <- u.ID
+			}()
+		}
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Create failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %s issued twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != perReplica*len(replicas) {
+		t.Errorf("got %d distinct IDs, want %d", len(seen), perReplica*len(replicas))
+	}
+}
+
+func TestSQLStore_UniqueEmail(t *testing.T) {
+	store := openTestSQLStore(t, filepath.Join(t.TempDir(), "users.db"))
+	createUsers(t, store, "Alice")
+
+	err := store.Create(context.Background(), &User{Name: "Other", Email: "Alice@example.com"})
+	if !errors.Is(err, ErrEmailExists) {
+		t.Errorf("expected ErrEmailExists, got %v", err)
+	}
+}
+
+func testStores() map[string]func(t *testing.T) UserStore {
+	return map[string]func(t *testing.T) UserStore{
+		"memory": func(t *testing.T) UserStore { return NewMemoryStore() },
+		"file": func(t *testing.T) UserStore {
+			store, err := OpenFileStore(t.TempDir(), FileStoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+		"sql": func(t *testing.T) UserStore {
+			return openTestSQLStore(t, filepath.Join(t.TempDir(), "users.db"))
+		},
+	}
+}
+
+func TestStores_UpdateAndList(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+			createUsers(t, store, "Alice", "Bob", "Carol")
+
+			deletedAt := time.Now().UTC().Truncate(time.Second)
+			updated, err := store.Update(ctx, "user-2", func(u *User) error {
+				u.Name = "Robert"
+				u.DeletedAt = &deletedAt
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if updated.Name != "Robert" || updated.ID != "user-2" {
+				t.Errorf("Update returned %+v", updated)
+			}
+
+			abort := errors.New("abort")
+			if _, err := store.Update(ctx, "user-3", func(u *User) error {
+				u.Name = "changed"
+				return abort
+			}); !errors.Is(err, abort) {
+				t.Errorf("expected fn error, got %v", err)
+			}
+			if _, err := store.Update(ctx, "user-9", func(*User) error { return nil }); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+
+			users, err := store.List(ctx, ListQuery{})
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(users) != 3 {
+				t.Fatalf("List returned %d users, want 3", len(users))
+			}
+			want := []string{"Alice", "Robert", "Carol"}
+			for i, u := range users {
+				if u.Name != want[i] {
+					t.Errorf("users[%d].Name = %q, want %q", i, u.Name, want[i])
+				}
+			}
+			if users[1].DeletedAt == nil || !users[1].DeletedAt.Equal(deletedAt) {
+				t.Errorf("DeletedAt = %v, want %v", users[1].DeletedAt, deletedAt)
+			}
+		})
+	}
+}
+
+func TestStores_ListQuery(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	seed := []*User{
+		{Name: "carol", Email: "carol@example.com", CreatedAt: base.Add(1 * time.Minute)},
+		{Name: "alice", Email: "alice@corp.example", Status: StatusSuspended, CreatedAt: base.Add(2 * time.Minute)},
+		{Name: "Albert", Email: "albert@example.com", CreatedAt: base.Add(3 * time.Minute)},
+		{Name: "bob", Email: "bob@corp.example", CreatedAt: base.Add(3 * time.Minute)},
+	}
+	deletedAt := base.Add(time.Hour)
+	live := []Status{StatusPendingVerification, StatusActive, StatusSuspended}
+
+	names := func(users []*User) []string {
+		var out []string
+		for _, u := range users {
+			out = append(out, u.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query ListQuery
+		want  []string
+	}{
+		{
+			name:  "default order is creation time then ID",
+			query: ListQuery{Statuses: live},
+			want:  []string{"carol", "alice", "Albert"},
+		},
+		{
+			name:  "no status filter includes deleted",
+			query: ListQuery{},
+			want:  []string{"carol", "alice", "Albert", "bob"},
+		},
+		{
+			name:  "only deleted",
+			query: ListQuery{Statuses: []Status{StatusDeleted}},
+			want:  []string{"bob"},
+		},
+		{
+			name:  "only suspended",
+			query: ListQuery{Statuses: []Status{StatusSuspended}},
+			want:  []string{"alice"},
+		},
+		{
+			name:  "order by name descending",
+			query: ListQuery{OrderBy: OrderByName, Descending: true},
+			want:  []string{"carol", "bob", "alice", "Albert"},
+		},
+		{
+			name:  "name prefix is case-insensitive",
+			query: ListQuery{Statuses: live, NamePrefix: "AL"},
+			want:  []string{"alice", "Albert"},
+		},
+		{
+			name:  "email prefix",
+			query: ListQuery{EmailPrefix: "b"},
+			want:  []string{"bob"},
+		},
+		{
+			name:  "prefix wildcards are literal",
+			query: ListQuery{NamePrefix: "%"},
+			want:  nil,
+		},
+		{
+			name:  "resume after cursor with limit",
+			query: ListQuery{After: &ListCursor{CreatedAt: base.Add(2 * time.Minute).UnixNano(), Seq: 2}, Limit: 1},
+			want:  []string{"Albert"},
+		},
+		{
+			name:  "resume after cursor descending",
+			query: ListQuery{OrderBy: OrderByName, Descending: true, After: &ListCursor{Name: "bob", Seq: 4}},
+			want:  []string{"alice", "Albert"},
+		},
+	}
+
+	for storeName, open := range testStores() {
+		t.Run(storeName, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+			for _, u := range seed {
+				cp := *u
+				if err := store.Create(ctx, &cp); err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+			}
+			if _, err := store.Update(ctx, "user-4", func(u *User) error {
+				u.Status = StatusDeleted
+				u.DeletedAt = &deletedAt
+				return nil
+			}); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					users, err := store.List(ctx, tt.query)
+					if err != nil {
+						t.Fatalf("List failed: %v", err)
+					}
+					if got := names(users); !slices.Equal(got, tt.want) {
+						t.Errorf("got %v, want %v", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestStores_UniqueEmail(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+			createUsers(t, store, "ann", "bob")
+
+			err := store.Create(ctx, &User{Name: "dup", Email: "ANN@Example.com"})
+			var conflict *EmailConflictError
+			if !errors.As(err, &conflict) || !errors.Is(err, ErrEmailExists) {
+				t.Fatalf("expected *EmailConflictError, got %v", err)
+			}
+			if conflict.UserID != "user-1" {
+				t.Errorf("conflict.UserID = %q, want %q", conflict.UserID, "user-1")
+			}
+
+			if _, err := store.Update(ctx, "user-2", func(u *User) error {
+				u.Email = "Ann@example.com"
+				return nil
+			}); !errors.Is(err, ErrEmailExists) {
+				t.Errorf("Update onto a taken email: expected ErrEmailExists, got %v", err)
+			}
+
+			// Changing case of one's own address is not a conflict, and the
+			// old address is released once it changes.
+			if _, err := store.Update(ctx, "user-1", func(u *User) error {
+				u.Email = "Ann@example.com"
+				return nil
+			}); err != nil {
+				t.Errorf("Update own email case: %v", err)
+			}
+			if _, err := store.Update(ctx, "user-2", func(u *User) error {
+				u.Email = "robert@example.com"
+				return nil
+			}); err != nil {
+				t.Fatalf("Update email: %v", err)
+			}
+			if err := store.Create(ctx, &User{Name: "new bob", Email: "bob@example.com"}); err != nil {
+				t.Errorf("released email should be reusable: %v", err)
+			}
+		})
+	}
+}
+
+func TestStores_UniqueEmailIsAtomic(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+
+			const attempts = 10
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			created := 0
+			for i := 0; i < attempts; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.Create(context.Background(), &User{Name: "racer", Email: "race@example.com"})
+					if err == nil {
+						mu.Lock()
+						created++
+						mu.Unlock()
+					} else if !errors.Is(err, ErrEmailExists) {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if created != 1 {
+				t.Errorf("%d concurrent creates succeeded, want exactly 1", created)
+			}
+		})
+	}
+}
+
+func TestStores_Verification(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+
+			u := &User{
+				Name:         "ann",
+				Email:        "ann@example.com",
+				Status:       StatusPendingVerification,
+				Verification: &Verification{TokenHash: "abc123", ExpiresAt: expiresAt},
+			}
+			if err := store.Create(ctx, u); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			got, err := store.Get(ctx, u.ID)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if got.Verification == nil || got.Verification.TokenHash != "abc123" || !got.Verification.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Verification = %+v, want hash abc123 expiring %v", got.Verification, expiresAt)
+			}
+
+			if _, err := store.Update(ctx, u.ID, func(u *User) error {
+				u.Verification = nil
+				return nil
+			}); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if got, _ := store.Get(ctx, u.ID); got.Verification != nil {
+				t.Errorf("Verification = %+v after clearing, want nil", got.Verification)
+			}
+		})
+	}
+}
+
+func TestStores_GetByEmail(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+
+			u := &User{Name: "ann", Email: "Ann@example.com", PasswordHash: "$argon2id$hash"}
+			if err := store.Create(ctx, u); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			got, err := store.GetByEmail(ctx, "ann@EXAMPLE.com")
+			if err != nil {
+				t.Fatalf("GetByEmail failed: %v", err)
+			}
+			if got.ID != u.ID || got.PasswordHash != "$argon2id$hash" {
+				t.Errorf("GetByEmail = %+v, want %s with its password hash", got, u.ID)
+			}
+
+			if _, err := store.Update(ctx, u.ID, func(u *User) error {
+				u.Email = "ann@example.org"
+				return nil
+			}); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if _, err := store.GetByEmail(ctx, "ann@example.com"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("old email: expected ErrNotFound, got %v", err)
+			}
+			if got, err := store.GetByEmail(ctx, "ann@example.org"); err != nil || got.PasswordHash != "$argon2id$hash" {
+				t.Errorf("new email: got %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestStores_CountByStatus(t *testing.T) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := open(t)
+			defer store.Close()
+			for _, name := range []string{"ann", "bob", "cat", "dan"} {
+				if err := store.Create(ctx, &User{Name: name, Email: name + "@example.com", Status: StatusPendingVerification}); err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+			}
+			for id, to := range map[string]Status{"user-1": StatusActive, "user-2": StatusActive, "user-3": StatusSuspended} {
+				if _, err := store.Update(ctx, id, func(u *User) error { return u.transition(to) }); err != nil {
+					t.Fatalf("Update failed: %v", err)
+				}
+			}
+			if _, err := store.Update(ctx, "user-2", func(u *User) error { return u.transition(StatusDeleted) }); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+
+			counts, err := store.CountByStatus(ctx)
+			if err != nil {
+				t.Fatalf("CountByStatus failed: %v", err)
+			}
+			want := map[Status]int{StatusPendingVerification: 1, StatusActive: 1, StatusSuspended: 1, StatusDeleted: 1}
+			for _, st := range []Status{StatusPendingVerification, StatusActive, StatusSuspended, StatusDeleted} {
+				if counts[st] != want[st] {
+					t.Errorf("counts[%s] = %d, want %d", st, counts[st], want[st])
+				}
+			}
+		})
+	}
+}
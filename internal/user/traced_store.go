@@ -0,0 +1,106 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this package
+const tracerName = "github.com/mr1hm/grpc-demo/internal/user"
+
+const userIDKey = attribute.Key("user.id")
+
+// tracedStore records a span around every operation on a UserStore
+type tracedStore struct {
+	store  UserStore
+	tracer trace.Tracer
+}
+
+// newTracedStore wraps store using the global tracer provider
+func newTracedStore(store UserStore) *tracedStore {
+	return &tracedStore{store: store, tracer: otel.Tracer(tracerName)}
+}
+
+func (s *tracedStore) Get(ctx context.Context, id string) (*User, error) {
+	ctx, span := s.tracer.Start(ctx, "UserStore.Get", trace.WithAttributes(userIDKey.String(id)))
+	u, err := s.store.Get(ctx, id)
+	endSpan(span, err)
+	return u, err
+}
+
+func (s *tracedStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := s.tracer.Start(ctx, "UserStore.GetByEmail")
+	u, err := s.store.GetByEmail(ctx, email)
+	if err == nil {
+		span.SetAttributes(userIDKey.String(u.ID))
+	}
+	endSpan(span, err)
+	return u, err
+}
+
+func (s *tracedStore) Create(ctx context.Context, u *User) error {
+	ctx, span := s.tracer.Start(ctx, "UserStore.Create")
+	err := s.store.Create(ctx, u)
+	if err == nil {
+		span.SetAttributes(userIDKey.String(u.ID))
+	}
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedStore) Update(ctx context.Context, id string, fn func(*User) error) (*User, error) {
+	ctx, span := s.tracer.Start(ctx, "UserStore.Update", trace.WithAttributes(userIDKey.String(id)))
+	var fnErr error
+	u, err := s.store.Update(ctx, id, func(u *User) error {
+		fnErr = fn(u)
+		return fnErr
+	})
+	spanErr := err
+	if fnErr != nil && errors.Is(err, fnErr) {
+		// The caller rejected the change; the store itself worked
+		span.AddEvent("update rejected")
+		spanErr = nil
+	}
+	endSpan(span, spanErr)
+	return u, err
+}
+
+func (s *tracedStore) List(ctx context.Context, q ListQuery) ([]*User, error) {
+	ctx, span := s.tracer.Start(ctx, "UserStore.List", trace.WithAttributes(
+		attribute.String("user.list.order_by", q.OrderBy),
+		attribute.Int("user.list.limit", q.Limit),
+	))
+	users, err := s.store.List(ctx, q)
+	if err == nil {
+		span.SetAttributes(attribute.Int("user.list.count", len(users)))
+	}
+	endSpan(span, err)
+	return users, err
+}
+
+func (s *tracedStore) CountByStatus(ctx context.Context) (map[Status]int, error) {
+	ctx, span := s.tracer.Start(ctx, "UserStore.CountByStatus")
+	counts, err := s.store.CountByStatus(ctx)
+	endSpan(span, err)
+	return counts, err
+}
+
+func (s *tracedStore) Close() error {
+	return s.store.Close()
+}
+
+// endSpan marks span failed for store faults and ends it. A missing user or a
+// taken email address is an answer, not a fault; their messages may also
+// carry personal data.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrEmailExists) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Verification is an outstanding email verification token. Only a hash of the
+// token is stored, so a leaked store cannot be used to verify addresses.
+type Verification struct {
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Verification token failures. An unknown user and a wrong secret both yield
+// errInvalidVerificationToken, so a guessed token reveals nothing about which users exist.
+var (
+	errInvalidVerificationToken = errors.New("verification token is invalid or has already been used")
+	errExpiredVerificationToken = errors.New("verification token has expired")
+)
+
+// errNotPendingVerification reports a user with no email address left to verify
+var errNotPendingVerification = errors.New("user is not pending verification")
+
+// newVerificationSecret returns a random secret and the Verification that
+// redeems it. The token handed to the user is formatted by verificationToken
+// once the user's ID is known.
+func newVerificationSecret(now time.Time, ttl time.Duration) (string, *Verification) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return secret, &Verification{
+		TokenHash: hashVerificationSecret(secret),
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// verificationToken joins a user ID and secret into the token sent by email.
+// The ID lets the token be checked with a single lookup.
+func verificationToken(userID, secret string) string {
+	return userID + "." + secret
+}
+
+// parseVerificationToken splits a token into the user ID and secret
+func parseVerificationToken(token string) (userID, secret string, err error) {
+	userID, secret, ok := strings.Cut(token, ".")
+	if !ok || userID == "" || secret == "" {
+		return "", "", errInvalidVerificationToken
+	}
+	return userID, secret, nil
+}
+
+func hashVerificationSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// redeemVerification checks secret against u's outstanding token and, if it matches,
+// consumes the token and activates u
+func (u *User) redeemVerification(secret string, now time.Time) error {
+	v := u.Verification
+	if v == nil || u.Deleted() {
+		return errInvalidVerificationToken
+	}
+	if subtle.ConstantTimeCompare([]byte(v.TokenHash), []byte(hashVerificationSecret(secret))) != 1 {
+		return errInvalidVerificationToken
+	}
+	if now.After(v.ExpiresAt) {
+		return errExpiredVerificationToken
+	}
+	if from := u.currentStatus(); from != StatusPendingVerification {
+		return &TransitionError{From: from, To: StatusActive}
+	}
+
+	u.Verification = nil
+	return u.transition(StatusActive)
+}
+
+// renewVerification replaces u's outstanding token with v, invalidating the old one
+func (u *User) renewVerification(v *Verification) error {
+	if u.currentStatus() != StatusPendingVerification {
+		return errNotPendingVerification
+	}
+	u.Verification = v
+	return nil
+}
+
+// changeEmail moves u to email, which must be verified with the token v
+// redeems. An active user goes back to pending verification; a suspended one
+// stays suspended and is reactivated to pending verification.
+func (u *User) changeEmail(email string, v *Verification) error {
+	u.Email = email
+	u.Verification = v
+	if u.currentStatus() == StatusActive {
+		return u.transition(StatusPendingVerification)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package validation holds the input rules shared by the User and Gateway
+// services, so a request is judged the same way at either entry point.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Name limits, counted in Unicode code points after normalization
+const (
+	MinNameLength = 1
+	MaxNameLength = 100
+)
+
+// Email limits from RFC 5321
+const (
+	MaxEmailLength  = 254
+	MaxLocalLength  = 64
+	MaxDomainLength = 253
+)
+
+// Password limits. Length is counted in Unicode code points; the maximum
+// bounds the work of hashing.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+var emailFolder = cases.Fold()
+
+// Violations collects every invalid field in a request so they can be
+// reported together rather than one round trip at a time.
+type Violations struct {
+	fields []*errdetails.BadRequest_FieldViolation
+}
+
+// Add records a violation for field
+func (v *Violations) Add(field, description string) {
+	v.fields = append(v.fields, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+}
+
+// Err returns an InvalidArgument status carrying a google.rpc.BadRequest
+// detail with one entry per violation, or nil if there are none.
+func (v *Violations) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(v.fields))
+	for i, f := range v.fields {
+		names[i] = f.Field
+	}
+	st := status.Newf(codes.InvalidArgument, "invalid %s", strings.Join(names, ", "))
+	withDetails, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.fields})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// Name normalizes a display name to NFC, trims surrounding whitespace and
+// checks its length and characters. The normalized value is returned even
+// when it is invalid.
+func Name(v *Violations, field, name string) string {
+	if !utf8.ValidString(name) {
+		v.Add(field, "must be valid UTF-8")
+		return name
+	}
+	name = strings.TrimSpace(norm.NFC.String(name))
+
+	switch n := utf8.RuneCountInString(name); {
+	case n < MinNameLength:
+		v.Add(field, "must not be empty")
+	case n > MaxNameLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters", MaxNameLength))
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			v.Add(field, "must not contain control characters")
+			break
+		}
+	}
+	return name
+}
+
+// Email normalizes an address to NFC, trims surrounding whitespace,
+// lower-cases the domain and checks it is a bare RFC 5322 addr-spec
+// (no display name or angle brackets).
+func Email(v *Violations, field, email string) string {
+	if !utf8.ValidString(email) {
+		v.Add(field, "must be valid UTF-8")
+		return email
+	}
+	email = strings.TrimSpace(norm.NFC.String(email))
+	if email == "" {
+		v.Add(field, "must not be empty")
+		return email
+	}
+	if len(email) > MaxEmailLength {
+		v.Add(field, fmt.Sprintf("must be at most %d bytes", MaxEmailLength))
+		return email
+	}
+
+	// Re-rendering the parsed address must give back the input; anything else
+	// means a display name, comment or other decoration was present.
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || (&mail.Address{Address: addr.Address}).String() != "<"+email+">" {
+		v.Add(field, "must be a valid email address such as name@example.com")
+		return email
+	}
+
+	at := strings.LastIndexByte(email, '@')
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if len(local) > MaxLocalLength {
+		v.Add(field, fmt.Sprintf("local part must be at most %d bytes", MaxLocalLength))
+	}
+	if len(domain) > MaxDomainLength {
+		v.Add(field, fmt.Sprintf("domain must be at most %d bytes", MaxDomainLength))
+	}
+	return local + "@" + domain
+}
+
+// Password checks a new password's length. Passwords are compared exactly as
+// given, so unlike names they are neither normalized nor trimmed.
+func Password(v *Violations, field, password string) {
+	if !utf8.ValidString(password) {
+		v.Add(field, "must be valid UTF-8")
+		return
+	}
+	switch n := utf8.RuneCountInString(password); {
+	case n < MinPasswordLength:
+		v.Add(field, fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+	case n > MaxPasswordLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters", MaxPasswordLength))
+	}
+}
+
+// EmailKey returns the form of an address used to decide whether two emails
+// belong to the same person: compatibility-normalized and case-folded, so
+// "Ann@Example.com" and "ann@example.com" collide.
+func EmailKey(email string) string {
+	return emailFolder.String(norm.NFKC.String(strings.TrimSpace(email)))
+}
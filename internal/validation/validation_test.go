@@ -0,0 +1,150 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", input: "Alice", want: "Alice"},
+		{name: "trimmed", input: "  Alice \t", want: "Alice"},
+		{name: "decomposed accent is composed", input: "Rene\u0301e", want: "Ren\u00e9e"},
+		{name: "multi-byte at the limit", input: strings.Repeat("é", MaxNameLength), want: strings.Repeat("é", MaxNameLength)},
+		{name: "empty", input: "", wantErr: true},
+		{name: "whitespace only", input: "   ", wantErr: true},
+		{name: "too long", input: strings.Repeat("a", MaxNameLength+1), wantErr: true},
+		{name: "control character", input: "Al\x00ice", wantErr: true},
+		{name: "invalid utf-8", input: "Al\xffice", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Violations
+			got := Name(&v, "name", tt.input)
+			if err := v.Err(); (err != nil) != tt.wantErr {
+				t.Fatalf("Err() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Name() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{name: "at the minimum", input: strings.Repeat("a", MinPasswordLength)},
+		{name: "multi-byte at the maximum", input: strings.Repeat("é", MaxPasswordLength)},
+		{name: "surrounding spaces count", input: "  passwd  "},
+		{name: "too short", input: strings.Repeat("a", MinPasswordLength-1), wantErr: true},
+		{name: "too long", input: strings.Repeat("a", MaxPasswordLength+1), wantErr: true},
+		{name: "invalid utf-8", input: "pass\xffword", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Violations
+			Password(&v, "password", tt.input)
+			if err := v.Err(); (err != nil) != tt.wantErr {
+				t.Fatalf("Err() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", input: "john@example.com", want: "john@example.com"},
+		{name: "domain is lower-cased", input: "John@Example.COM", want: "John@example.com"},
+		{name: "trimmed", input: " john@example.com ", want: "john@example.com"},
+		{name: "plus tag", input: "john+tag@example.com", want: "john+tag@example.com"},
+		{name: "quoted local part", input: `"john doe"@example.com`, want: `"john doe"@example.com`},
+		{name: "empty", input: "", wantErr: true},
+		{name: "no at sign", input: "x", wantErr: true},
+		{name: "no domain", input: "john@", wantErr: true},
+		{name: "display name", input: "John <john@example.com>", wantErr: true},
+		{name: "angle brackets", input: "<john@example.com>", wantErr: true},
+		{name: "comment", input: "john@example.com (John)", wantErr: true},
+		{name: "two addresses", input: "a@example.com, b@example.com", wantErr: true},
+		{name: "local part too long", input: strings.Repeat("a", MaxLocalLength+1) + "@example.com", wantErr: true},
+		{name: "address too long", input: "a@" + strings.Repeat("b", MaxEmailLength) + ".com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Violations
+			got := Email(&v, "email", tt.input)
+			if err := v.Err(); (err != nil) != tt.wantErr {
+				t.Fatalf("Err() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Email() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestViolations_Err(t *testing.T) {
+	var v Violations
+	if err := v.Err(); err != nil {
+		t.Fatalf("empty Violations should not be an error, got %v", err)
+	}
+
+	Name(&v, "name", "")
+	Email(&v, "email", "x")
+	st, ok := status.FromError(v.Err())
+	if !ok || st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument status, got %v", v.Err())
+	}
+
+	var br *errdetails.BadRequest
+	for _, d := range st.Details() {
+		if b, ok := d.(*errdetails.BadRequest); ok {
+			br = b
+		}
+	}
+	if br == nil {
+		t.Fatal("expected a BadRequest detail")
+	}
+	if len(br.FieldViolations) != 2 || br.FieldViolations[0].Field != "name" || br.FieldViolations[1].Field != "email" {
+		t.Errorf("FieldViolations = %v, want name and email", br.FieldViolations)
+	}
+}
+
+func TestEmailKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{a: "ann@example.com", b: "ANN@Example.COM", same: true},
+		{a: "ann@example.com", b: " ann@example.com ", same: true},
+		{a: "re\u0301ne@example.com", b: "r\u00e9ne@example.com", same: true},
+		{a: "\uff41nn@example.com", b: "ann@example.com", same: true}, // fullwidth a
+		{a: "ann@example.com", b: "anne@example.com", same: false},
+	}
+
+	for _, tt := range tests {
+		if got := EmailKey(tt.a) == EmailKey(tt.b); got != tt.same {
+			t.Errorf("EmailKey(%q) == EmailKey(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v4.23.3
+// source: proto/redactpb/redact.proto
+
+package redactpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+var file_proto_redactpb_redact_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50100,
+		Name:          "redactpb.sensitive",
+		Tag:           "varint,50100,opt,name=sensitive",
+		Filename:      "proto/redactpb/redact.proto",
+	},
+}
+
+// Extension fields to descriptorpb.FieldOptions.
+var (
+	// Marks a field holding personal data. Logged messages show a hash of the
+	// value instead of the value itself.
+	//
+	// optional bool sensitive = 50100;
+	E_Sensitive = &file_proto_redactpb_redact_proto_extTypes[0]
+)
+
+var File_proto_redactpb_redact_proto protoreflect.FileDescriptor
+
+const file_proto_redactpb_redact_proto_rawDesc = "" +
+	"\n" +
+	"\x1bproto/redactpb/redact.proto\x12\bredactpb\x1a google/protobuf/descriptor.proto:=\n" +
+	"\tsensitive\x12\x1d.google.protobuf.FieldOptions\x18\xb4\x87\x03 \x01(\bR\tsensitiveB+Z)github.com/mr1hm/grpc-demo/proto/redactpbb\x06proto3"
+
+var file_proto_redactpb_redact_proto_goTypes = []any{
+	(*descriptorpb.FieldOptions)(nil), // 0: google.protobuf.FieldOptions
+}
+var file_proto_redactpb_redact_proto_depIdxs = []int32{
+	0, // 0: redactpb.sensitive:extendee -> google.protobuf.FieldOptions
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proto_redactpb_redact_proto_init() }
+func file_proto_redactpb_redact_proto_init() {
+	if File_proto_redactpb_redact_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_redactpb_redact_proto_rawDesc), len(file_proto_redactpb_redact_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   0,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_proto_redactpb_redact_proto_goTypes,
+		DependencyIndexes: file_proto_redactpb_redact_proto_depIdxs,
+		ExtensionInfos:    file_proto_redactpb_redact_proto_extTypes,
+	}.Build()
+	File_proto_redactpb_redact_proto = out.File
+	file_proto_redactpb_redact_proto_goTypes = nil
+	file_proto_redactpb_redact_proto_depIdxs = nil
+}